@@ -0,0 +1,202 @@
+package congestion
+
+import (
+	"math"
+	"time"
+)
+
+// Sample describes the state of a Limiter when a LimitAlgorithm is consulted.
+type Sample struct {
+	// Limit is the current concurrency limit
+	Limit int
+	// MinLimit is the smallest limit the Limiter will accept
+	MinLimit int
+	// MaxLimit is the largest limit the Limiter will accept
+	MaxLimit int
+	// Inflight is the number of outstanding tokens, including the one being released
+	Inflight int
+	// RTT is how long the token was held, or zero if it is unknown
+	RTT time.Duration
+	// Weight is the number of slots being released, for tokens
+	// acquired with AcquireN. Zero is treated as one.
+	Weight int
+}
+
+// weight returns the number of slots being released, at least one.
+func (s Sample) weight() int {
+	if s.Weight < 1 {
+		return 1
+	}
+	return s.Weight
+}
+
+// LimitAlgorithm decides the concurrency limit of a Limiter. It is
+// always called with the Limiter's lock held, so implementations do
+// not need their own synchronization, but must not block.
+type LimitAlgorithm interface {
+	// Success is called when a token is released, and returns the new limit.
+	Success(s Sample) int
+	// Backoff is called when the upstream signals it is overloaded, and returns the new limit.
+	Backoff(s Sample) int
+}
+
+// Restarter is implemented by LimitAlgorithms that want to adjust the
+// limit when a Limiter is used again after being idle.
+type Restarter interface {
+	// Restart is called when a token is admitted after the Limiter had
+	// nothing outstanding for the idle duration, and returns the new limit.
+	Restart(s Sample, idle time.Duration) int
+}
+
+// aimd is the default LimitAlgorithm. It starts by multiplying the
+// limit by SlowStartFactor every round trip, then after the first
+// backoff waits a full round before increasing the limit by IncreaseBy
+// every round trip. Backoffs multiply the limit by DecreaseFactor.
+//
+// Like TCP Reno, it remembers the limit at the last backoff as a slow
+// start threshold. After recovering it uses slow start to grow quickly
+// back up to that threshold, and only then increases linearly. After
+// being idle for IdleTimeout it restarts in slow start from the
+// initial limit.
+type aimd struct {
+	stage    Stage
+	acksLeft int
+	ssthresh int
+
+	initialLimit    int
+	increaseBy      int
+	decreaseFactor  float64
+	slowStartFactor float64
+	idleTimeout     time.Duration
+}
+
+func newAIMD(cfg Config) *aimd {
+	cfg = cfg.withDefaults()
+
+	return &aimd{
+		stage:           SlowStart,
+		acksLeft:        cfg.InitialLimit,
+		initialLimit:    cfg.InitialLimit,
+		increaseBy:      cfg.IncreaseBy,
+		decreaseFactor:  cfg.DecreaseFactor,
+		slowStartFactor: cfg.SlowStartFactor,
+		idleTimeout:     cfg.IdleTimeout,
+	}
+}
+
+func (a *aimd) Success(s Sample) int {
+	limit := s.Limit
+
+	// If we are waiting on acks, decrement and move on
+	if a.stage == Recovering {
+		a.acksLeft = limit
+		// Implement a waiting period of our limit before scaling again
+		a.stage = Waiting
+		return limit
+	}
+
+	// Each slot released counts as an ack
+	if a.acksLeft > s.weight() {
+		a.acksLeft -= s.weight()
+		return limit
+	}
+
+	switch a.stage {
+
+	case Waiting:
+		if s.Inflight == limit {
+			// Below the last backoff we can grow quickly back to it
+			if limit < a.ssthresh {
+				a.stage = SlowStart
+			} else {
+				a.stage = Increasing
+			}
+		}
+
+	// If we're in slow start, double our limit
+	case SlowStart:
+		limit = int(math.Ceil(float64(limit) * a.slowStartFactor))
+
+		// Once we are back to the last backoff, probe carefully
+		if a.ssthresh > 0 && limit >= a.ssthresh {
+			if s.Limit < a.ssthresh {
+				limit = a.ssthresh
+			} else {
+				limit = s.Limit + a.increaseBy
+			}
+			a.stage = Increasing
+		}
+
+	// If we're increasing increment
+	case Increasing:
+		limit += a.increaseBy
+	}
+
+	if limit > s.MaxLimit {
+		limit = s.MaxLimit
+	}
+
+	// reset acks left for next stage transition
+	a.acksLeft = limit
+	return limit
+}
+
+func (a *aimd) decrease(limit, minLimit int) int {
+	a.ssthresh = limit
+	limit = int(float64(limit) * a.decreaseFactor)
+	if limit < minLimit {
+		limit = minLimit
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	a.acksLeft = limit
+	return limit
+}
+
+func (a *aimd) Backoff(s Sample) int {
+	limit := s.Limit
+
+	switch a.stage {
+
+	// Decrease limit if we were not recovering
+	case SlowStart:
+		limit = a.decrease(limit, s.MinLimit)
+	case Waiting:
+		limit = a.decrease(limit, s.MinLimit)
+	case Increasing:
+		limit = a.decrease(limit, s.MinLimit)
+
+	// If we are recovering for more than the ack period, we decrease the limit again
+	case Recovering:
+		if a.acksLeft > 1 {
+			a.acksLeft--
+		} else {
+			limit = a.decrease(limit, s.MinLimit)
+		}
+	}
+
+	a.stage = Recovering
+	return limit
+}
+
+func (a *aimd) Restart(s Sample, idle time.Duration) int {
+	if a.idleTimeout <= 0 || idle < a.idleTimeout {
+		return s.Limit
+	}
+
+	// Our limit is stale, so remember it as a threshold, and slow start
+	// from the initial limit.
+	if s.Limit > a.ssthresh {
+		a.ssthresh = s.Limit
+	}
+
+	limit := s.Limit
+	if a.initialLimit < limit {
+		limit = a.initialLimit
+	}
+
+	a.stage = SlowStart
+	a.acksLeft = limit
+	return limit
+}
@@ -15,16 +15,43 @@ type Backoff struct {
 
 	runs        int
 	shouldClose bool
+	closed      bool
+	retryAfter  time.Duration
 }
 
-// Close will close resources associated with the Backoff
-func (r *Backoff) Close() {
+// RetryAfter sets the delay before the next retry, as provided by the
+// upstream, e.g. in a Retry-After header. The next Try pauses the
+// Limiter for the delay instead of decreasing its limit, and waits
+// exactly that long instead of its own exponential step.
+func (r *Backoff) RetryAfter(delay time.Duration) {
+	r.retryAfter = delay
+}
+
+// release the underlying limiter, if we hold it
+func (r *Backoff) release() {
 	if r.shouldClose {
 		r.Limiter.Release()
 		r.shouldClose = false
 	}
 }
 
+// Close will close resources associated with the Backoff
+func (r *Backoff) Close() {
+	r.release()
+
+	if r.Limiter == nil {
+		return
+	}
+
+	if o := r.Limiter.observer; o != nil && r.runs > 0 && !r.closed {
+		r.closed = true
+		o.Closed(BackoffEvent{
+			Retries: r.runs - 1,
+			Err:     r.Error,
+		})
+	}
+}
+
 // acquire will acquire the underlying limiter, managing state
 func (r *Backoff) acquire(ctx context.Context) bool {
 	err := r.Limiter.Acquire(ctx, r.Priority)
@@ -44,14 +71,25 @@ func (r *Backoff) Try(ctx context.Context) bool {
 		return r.acquire(ctx)
 	}
 
-	// Otherwise, we are retrying, and have to signal a backoff
-	r.Limiter.Backoff()
-	r.Close()
+	// Otherwise, we are retrying, and have to signal a backoff. If the
+	// upstream told us how long to wait, use that.
+	var sleep time.Duration
+	if r.retryAfter > 0 {
+		sleep = r.retryAfter
+		r.retryAfter = 0
+		r.Limiter.BackoffUntil(time.Now().Add(sleep))
+	} else {
+		sleep = time.Duration((rand.Float64() + 0.5) * float64(r.Step))
+		r.Limiter.Backoff()
+
+		// Update our step
+		r.Step = (r.Step * 3) / 2
+	}
+	r.release()
 
-	// Generate the next time this retry can run, and check if that is after the deadline
-	nextWakeup := time.Now().Add(time.Duration((rand.Float64() + 0.5) * float64(r.Step)))
+	// Check if the next time this retry can run is after the deadline
 	if deadline, ok := ctx.Deadline(); ok {
-		if nextWakeup.After(deadline) {
+		if time.Now().Add(sleep).After(deadline) {
 			r.Error = context.DeadlineExceeded
 			return false
 		}
@@ -59,29 +97,28 @@ func (r *Backoff) Try(ctx context.Context) bool {
 
 	// Increase our priority that way we get scheduled ahead of other similar priority traffic
 	r.Priority++
-	// Update our step
-	r.Step = (r.Step * 3) / 2
+	r.runs++
 
-	// Re-enqueue at our new priority
-	if !r.acquire(ctx) {
-		return false
+	if o := r.Limiter.observer; o != nil {
+		o.Retried(ctx, RetryEvent{
+			Retry:    r.runs - 1,
+			Priority: r.Priority,
+			Sleep:    sleep,
+		})
 	}
 
-	// If we've already been delayed enough, go ahead
-	timeLeft := time.Now().Sub(nextWakeup)
-	if timeLeft < 0 {
-		return true
-	}
+	// Wait out the backoff before re-enqueueing, that way we don't hold a
+	// slot while idle
+	t := time.NewTimer(sleep)
+	defer t.Stop()
 
-	// Otherwise, block until either the context is cancelled
-	t := time.NewTimer(timeLeft)
 	select {
 	case <-t.C:
-		t.Stop()
-		return true
 	case <-ctx.Done():
 		r.Error = ctx.Err()
 		return false
 	}
 
+	// Re-enqueue at our new priority
+	return r.acquire(ctx)
 }
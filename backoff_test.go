@@ -7,7 +7,7 @@ import (
 )
 
 func TestBackoff(t *testing.T) {
-	c := New(Config{10, 10})
+	c := New(Config{Capacity: 10, MaxLimit: 10})
 	b := Backoff{
 		Limiter: &c,
 		Step:    10 * time.Millisecond,
@@ -21,8 +21,13 @@ func TestBackoff(t *testing.T) {
 	b.Close()
 }
 
+func TestBackoffCloseWithoutLimiter(t *testing.T) {
+	b := Backoff{}
+	b.Close()
+}
+
 func TestBackoffTryFailsOnCancelledContext(t *testing.T) {
-	c := New(Config{10, 10})
+	c := New(Config{Capacity: 10, MaxLimit: 10})
 	c.limit = 0
 
 	b := Backoff{
@@ -40,3 +45,99 @@ func TestBackoffTryFailsOnCancelledContext(t *testing.T) {
 	}
 
 }
+
+func TestBackoffRetryAfter(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	c := New(Config{Capacity: 10, MaxLimit: 10, InitialLimit: 4})
+	b := Backoff{
+		Limiter: &c,
+		Step:    time.Hour,
+	}
+	defer b.Close()
+
+	ok := b.Try(context.Background())
+	if !ok {
+		t.Fatal("Try failed", b.Error)
+	}
+
+	b.RetryAfter(delay)
+	start := time.Now()
+
+	ok = b.Try(context.Background())
+	if !ok {
+		t.Fatal("Try failed", b.Error)
+	}
+
+	if waited := time.Since(start); waited < delay || waited > time.Minute {
+		t.Errorf("Waited %s, expected %s", waited, delay)
+	}
+
+	if c.limit != 4 {
+		t.Errorf("Got limit %d, expected it unchanged at %d", c.limit, 4)
+	}
+
+	if b.Step != time.Hour {
+		t.Errorf("Got step %s, expected it unchanged at %s", b.Step, time.Hour)
+	}
+}
+
+func TestBackoffSleepsBetweenRetries(t *testing.T) {
+	const step = 20 * time.Millisecond
+
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+	b := Backoff{
+		Limiter: &c,
+		Step:    step,
+	}
+	defer b.Close()
+
+	b.Try(context.Background())
+	start := time.Now()
+	b.Try(context.Background())
+
+	// The jitter is at least half the step
+	if waited := time.Since(start); waited < step/2 {
+		t.Errorf("Waited %s, expected at least %s", waited, step/2)
+	}
+}
+
+func TestBackoffReleasesWhileSleeping(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+	b := Backoff{
+		Limiter: &c,
+		Step:    time.Hour,
+	}
+
+	ok := b.Try(context.Background())
+	if !ok {
+		t.Fatal("Try failed", b.Error)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		done <- b.Try(ctx)
+	}()
+
+	// The slot isn't held during the sleep
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Outstanding != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d outstanding while sleeping, expected %d", c.Stats().Outstanding, 0)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	if <-done {
+		t.Error("Expected the retry to fail")
+	}
+
+	b.Close()
+
+	if s := c.Stats(); s.Outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 0)
+	}
+}
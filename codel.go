@@ -0,0 +1,75 @@
+package congestion
+
+import (
+	"math"
+	"time"
+)
+
+// codel implements CoDel, controlled delay, queue management. A queue
+// that briefly grows is fine, but one whose delay stays above the
+// target for a whole interval is standing, and needs to shed waiters to
+// drain. While it is, drops are spaced by interval/sqrt(count), so they
+// come faster until the delay falls back under the target.
+//
+// Waiters are ordered by priority, so rather than dropping the waiter
+// being admitted as CoDel usually would, the lowest priority one is.
+type codel struct {
+	target   time.Duration
+	interval time.Duration
+
+	// firstAbove is when the delay will have been above target for an interval
+	firstAbove time.Time
+	// dropNext is when the next drop is due, while dropping
+	dropNext time.Time
+	count    int
+	dropping bool
+}
+
+// admitted is called with how long each waiter was queued as it is
+// admitted, and returns if one of the queued waiters should be dropped.
+// At most one is dropped per admitted waiter, so when admissions are
+// spaced further apart than the drops are due, it falls behind rather
+// than shedding the whole queue at once.
+func (c *codel) admitted(now time.Time, sojourn time.Duration, queued int) bool {
+	if sojourn < c.target {
+		c.firstAbove = time.Time{}
+		c.dropping = false
+		return false
+	}
+
+	if c.firstAbove.IsZero() {
+		c.firstAbove = now.Add(c.interval)
+		return false
+	}
+
+	if now.Before(c.firstAbove) {
+		return false
+	}
+
+	if !c.dropping {
+		c.dropping = true
+
+		// If we were dropping recently, pick up close to that rate
+		if c.count > 2 && now.Sub(c.dropNext) < 16*c.interval {
+			c.count -= 2
+		} else {
+			c.count = 1
+		}
+
+		c.dropNext = c.next(now)
+		return queued > 0
+	}
+
+	if now.Before(c.dropNext) || queued == 0 {
+		return false
+	}
+
+	c.count++
+	c.dropNext = c.next(c.dropNext)
+	return true
+}
+
+// next returns when the drop after the one at t is due.
+func (c *codel) next(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(c.interval) / math.Sqrt(float64(c.count))))
+}
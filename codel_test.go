@@ -0,0 +1,161 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCoDel(t *testing.T) {
+	c := codel{target: 5 * time.Millisecond, interval: 100 * time.Millisecond}
+	now := time.Now()
+
+	cases := []struct {
+		After    time.Duration
+		Sojourn  time.Duration
+		Expected bool
+	}{
+		// A short queue is fine
+		{0, time.Millisecond, false},
+		// It needs to stay above the target for an interval
+		{0, 10 * time.Millisecond, false},
+		{50 * time.Millisecond, 10 * time.Millisecond, false},
+		{100 * time.Millisecond, 10 * time.Millisecond, true},
+		// Then drops come faster
+		{150 * time.Millisecond, 10 * time.Millisecond, false},
+		{200 * time.Millisecond, 10 * time.Millisecond, true},
+		{271 * time.Millisecond, 10 * time.Millisecond, true},
+		// Even when more are due, only one is dropped at a time
+		{400 * time.Millisecond, 10 * time.Millisecond, true},
+		// Until it drops under the target
+		{401 * time.Millisecond, time.Millisecond, false},
+		{500 * time.Millisecond, 10 * time.Millisecond, false},
+	}
+
+	for _, tc := range cases {
+		if drop := c.admitted(now.Add(tc.After), tc.Sojourn, 10); drop != tc.Expected {
+			t.Errorf("Got drop %t after %s with sojourn %s, expected %t", drop, tc.After, tc.Sojourn, tc.Expected)
+		}
+	}
+}
+
+func TestCoDelDropsOnlyQueued(t *testing.T) {
+	c := codel{target: 5 * time.Millisecond, interval: 100 * time.Millisecond}
+	now := time.Now()
+
+	c.admitted(now, 10*time.Millisecond, 10)
+	c.admitted(now.Add(100*time.Millisecond), 10*time.Millisecond, 10)
+
+	if c.admitted(now.Add(time.Hour), 10*time.Millisecond, 0) {
+		t.Error("Expected no drop with nothing queued")
+	}
+}
+
+func TestLimiterCoDel(t *testing.T) {
+	c := New(Config{
+		Capacity:      10,
+		MaxLimit:      1,
+		CoDelTarget:   time.Millisecond,
+		CoDelInterval: 10 * time.Millisecond,
+	})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func(priority int) {
+			err := c.Acquire(context.Background(), priority)
+			results <- err
+			if err == nil {
+				c.Release()
+			}
+		}(i)
+		waitForQueued(&c, i+1)
+	}
+
+	// The queue stands for longer than the interval
+	time.Sleep(20 * time.Millisecond)
+	c.mu.Lock()
+	c.codel.admitted(time.Now().Add(-10*time.Millisecond), 10*time.Millisecond, 0)
+	c.mu.Unlock()
+
+	c.Release()
+
+	dropped := 0
+	for i := 0; i < 3; i++ {
+		err := <-results
+		if errors.Is(err, Dropped) {
+			dropped++
+		} else if err != nil {
+			t.Error("Got an error:", err)
+		}
+	}
+
+	if dropped != 1 {
+		t.Errorf("Got %d dropped, expected %d", dropped, 1)
+	}
+
+	if s := c.Stats(); s.Drops != 1 || s.Outstanding != 0 {
+		t.Errorf("Got %d drops and %d outstanding, expected %d and %d", s.Drops, s.Outstanding, 1, 0)
+	}
+}
+
+func TestLimiterCoDelSpacedReleases(t *testing.T) {
+	const waiters = 9
+
+	c := New(Config{
+		Capacity:      10,
+		MaxLimit:      1,
+		CoDelTarget:   time.Millisecond,
+		CoDelInterval: 10 * time.Millisecond,
+	})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	results := make(chan error, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			results <- c.Acquire(context.Background(), 0)
+		}()
+		waitForQueued(&c, i+1)
+	}
+
+	// Each release comes after several drops would have been due
+	admitted, dropped := 0, 0
+	for admitted+dropped < waiters {
+		time.Sleep(30 * time.Millisecond)
+		c.Release()
+
+		// Wait for the next admitted, counting any dropped before it
+		for admitted+dropped < waiters {
+			err := <-results
+			if err == nil {
+				admitted++
+				break
+			}
+			if !errors.Is(err, Dropped) {
+				t.Fatal("Got an error:", err)
+			}
+			dropped++
+		}
+
+		if dropped > admitted {
+			t.Fatalf("Got %d dropped for %d admitted, expected at most one each", dropped, admitted)
+		}
+	}
+
+	if dropped == 0 {
+		t.Error("Expected the standing queue to be dropped from")
+	}
+
+	if c.Stats().Outstanding > 0 {
+		c.Release()
+	}
+}
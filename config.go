@@ -0,0 +1,135 @@
+package congestion
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidConfig is wrapped by the errors returned when validating a Config
+var ErrInvalidConfig = errors.New("invalid config")
+
+type Config struct {
+	// Capacity is the number of waiters that can be queued
+	Capacity int
+	// MaxLimit is the largest the limit can grow
+	MaxLimit int
+	// MinLimit is the smallest the limit can shrink. Defaults to 1.
+	MinLimit int
+	// InitialLimit is the limit to start at. Defaults to MinLimit.
+	InitialLimit int
+
+	// IncreaseBy is how much the AIMD algorithm grows the limit every
+	// round trip after its first backoff. Defaults to 1.
+	IncreaseBy int
+	// DecreaseFactor is what the AIMD algorithm multiplies the limit
+	// by on a backoff. Defaults to 0.75.
+	DecreaseFactor float64
+	// SlowStartFactor is what the AIMD algorithm multiplies the limit
+	// by every round trip while in slow start. Defaults to 2.
+	SlowStartFactor float64
+	// IdleTimeout is how long the Limiter can have nothing outstanding
+	// before the AIMD algorithm restarts in slow start. Zero disables this.
+	IdleTimeout time.Duration
+
+	// Algorithm returns a new LimitAlgorithm for each Limiter built from
+	// this Config. Defaults to an AIMD stage machine modeled after TCP.
+	Algorithm func() LimitAlgorithm
+
+	// Observer is notified of events in the Limiter, and in any Backoff using it
+	Observer Observer
+
+	// FairQueuing serves waiters of the same priority round robin by
+	// their tenant, from WithTenant, so one tenant can't fill the queue
+	// and starve the others.
+	FairQueuing bool
+
+	// EarliestDeadlineFirst serves waiters of the same priority by
+	// their context's deadline, soonest first. Waiters that can't be
+	// served before their deadline, given the limit and how long tokens
+	// are held, fail early with ErrDeadline.
+	EarliestDeadlineFirst bool
+
+	// DropPolicy decides which waiter is dropped when the queue is full.
+	// Defaults to DropLowestPriority.
+	DropPolicy DropPolicy
+
+	// LIFOThreshold switches waiters of the same priority from being
+	// served oldest first to newest first, once one has been queued
+	// longer than this. Under overload the oldest have likely been given
+	// up on by their callers. It switches back once the queue drains.
+	// Zero always serves the oldest first.
+	LIFOThreshold time.Duration
+
+	// CoDelTarget enables CoDel queue management. Once waiters have
+	// been queued longer than the target for a whole CoDelInterval, the
+	// lowest priority waiters are dropped, faster the longer it lasts,
+	// until the queue drains. Zero disables this.
+	CoDelTarget time.Duration
+	// CoDelInterval is how long the queue delay can stay above
+	// CoDelTarget before dropping starts. Defaults to 100ms.
+	CoDelInterval time.Duration
+
+	// Parent is acquired along with the Limiter, e.g. an account wide
+	// limit over per endpoint limits. Backoffs only apply to the
+	// Limiter they are signaled on.
+	Parent *Limiter
+}
+
+// withDefaults fills in unset fields with their defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.MinLimit == 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.InitialLimit == 0 {
+		cfg.InitialLimit = cfg.MinLimit
+	}
+	if cfg.IncreaseBy == 0 {
+		cfg.IncreaseBy = 1
+	}
+	if cfg.DecreaseFactor == 0 {
+		cfg.DecreaseFactor = 0.75
+	}
+	if cfg.SlowStartFactor == 0 {
+		cfg.SlowStartFactor = 2
+	}
+	if cfg.CoDelTarget > 0 && cfg.CoDelInterval == 0 {
+		cfg.CoDelInterval = 100 * time.Millisecond
+	}
+	return cfg
+}
+
+// Validate returns an error wrapping ErrInvalidConfig if the Config,
+// after defaults are applied, can't build a working Limiter.
+func (cfg Config) Validate() error {
+	cfg = cfg.withDefaults()
+
+	switch {
+	case cfg.Capacity < 0:
+		return fmt.Errorf("%w: Capacity %d is negative", ErrInvalidConfig, cfg.Capacity)
+	case cfg.MinLimit < 1:
+		return fmt.Errorf("%w: MinLimit %d is less than 1", ErrInvalidConfig, cfg.MinLimit)
+	case cfg.MaxLimit < cfg.MinLimit:
+		return fmt.Errorf("%w: MaxLimit %d is less than MinLimit %d", ErrInvalidConfig, cfg.MaxLimit, cfg.MinLimit)
+	case cfg.InitialLimit < cfg.MinLimit || cfg.InitialLimit > cfg.MaxLimit:
+		return fmt.Errorf("%w: InitialLimit %d is outside of MinLimit %d and MaxLimit %d", ErrInvalidConfig, cfg.InitialLimit, cfg.MinLimit, cfg.MaxLimit)
+	case cfg.IncreaseBy < 1:
+		return fmt.Errorf("%w: IncreaseBy %d is less than 1", ErrInvalidConfig, cfg.IncreaseBy)
+	case cfg.DecreaseFactor <= 0 || cfg.DecreaseFactor >= 1:
+		return fmt.Errorf("%w: DecreaseFactor %g is not between 0 and 1", ErrInvalidConfig, cfg.DecreaseFactor)
+	case cfg.SlowStartFactor <= 1:
+		return fmt.Errorf("%w: SlowStartFactor %g is not greater than 1", ErrInvalidConfig, cfg.SlowStartFactor)
+	case cfg.IdleTimeout < 0:
+		return fmt.Errorf("%w: IdleTimeout %s is negative", ErrInvalidConfig, cfg.IdleTimeout)
+	case cfg.DropPolicy < DropLowestPriority || cfg.DropPolicy > RejectIncoming:
+		return fmt.Errorf("%w: DropPolicy %d is unknown", ErrInvalidConfig, cfg.DropPolicy)
+	case cfg.LIFOThreshold < 0:
+		return fmt.Errorf("%w: LIFOThreshold %s is negative", ErrInvalidConfig, cfg.LIFOThreshold)
+	case cfg.CoDelTarget < 0:
+		return fmt.Errorf("%w: CoDelTarget %s is negative", ErrInvalidConfig, cfg.CoDelTarget)
+	case cfg.CoDelInterval < 0:
+		return fmt.Errorf("%w: CoDelInterval %s is negative", ErrInvalidConfig, cfg.CoDelInterval)
+	}
+
+	return nil
+}
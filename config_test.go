@@ -0,0 +1,82 @@
+package congestion
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Config Config
+		Valid  bool
+	}{
+		{"Defaults", Config{Capacity: 10, MaxLimit: 10}, true},
+		{"Everything", Config{Capacity: 10, MaxLimit: 100, MinLimit: 5, InitialLimit: 50, IncreaseBy: 2, DecreaseFactor: 0.5, SlowStartFactor: 1.5}, true},
+		{"NegativeCapacity", Config{Capacity: -1, MaxLimit: 10}, false},
+		{"NoMaxLimit", Config{Capacity: 10}, false},
+		{"NegativeMinLimit", Config{Capacity: 10, MaxLimit: 10, MinLimit: -1}, false},
+		{"MinAboveMax", Config{Capacity: 10, MaxLimit: 10, MinLimit: 20}, false},
+		{"InitialBelowMin", Config{Capacity: 10, MaxLimit: 10, MinLimit: 5, InitialLimit: 2}, false},
+		{"InitialAboveMax", Config{Capacity: 10, MaxLimit: 10, InitialLimit: 20}, false},
+		{"NegativeIncrease", Config{Capacity: 10, MaxLimit: 10, IncreaseBy: -1}, false},
+		{"DecreaseTooLarge", Config{Capacity: 10, MaxLimit: 10, DecreaseFactor: 1}, false},
+		{"NegativeDecrease", Config{Capacity: 10, MaxLimit: 10, DecreaseFactor: -0.5}, false},
+		{"SlowStartTooSmall", Config{Capacity: 10, MaxLimit: 10, SlowStartFactor: 0.5}, false},
+		{"RejectIncoming", Config{Capacity: 10, MaxLimit: 10, DropPolicy: RejectIncoming}, true},
+		{"UnknownDropPolicy", Config{Capacity: 10, MaxLimit: 10, DropPolicy: RejectIncoming + 1}, false},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			l, err := NewLimiter(tc.Config)
+
+			if tc.Valid && err != nil {
+				t.Errorf("Got an error: %v", err)
+			}
+
+			if !tc.Valid {
+				if !errors.Is(err, ErrInvalidConfig) {
+					t.Errorf("Got %v, expected %v", err, ErrInvalidConfig)
+				}
+				if l != nil {
+					t.Errorf("Got a limiter for an invalid config")
+				}
+			}
+		})
+	}
+}
+
+func TestConfigLimits(t *testing.T) {
+	l, err := NewLimiter(Config{
+		Capacity:        10,
+		MaxLimit:        100,
+		MinLimit:        4,
+		InitialLimit:    10,
+		IncreaseBy:      5,
+		DecreaseFactor:  0.5,
+		SlowStartFactor: 3,
+	})
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if l.limit != 10 {
+		t.Errorf("Got initial limit %d, expected %d", l.limit, 10)
+	}
+
+	l.Backoff()
+	if l.limit != 5 {
+		t.Errorf("Got limit %d after backoff, expected %d", l.limit, 5)
+	}
+
+	l.Backoff()
+	l.Backoff()
+	l.Backoff()
+	l.Backoff()
+	l.Backoff()
+	if l.limit != 4 {
+		t.Errorf("Got limit %d after repeated backoff, expected the minimum %d", l.limit, 4)
+	}
+}
@@ -4,176 +4,481 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 )
 
-// Dropped is the error that will be returned if this token is dropped
+// Dropped is matched by the errors returned when a waiter is dropped,
+// which are a *DropError with the details.
 var Dropped = errors.New("dropped")
 
-type Config struct {
-	Capacity int
-	MaxLimit int
-}
+// ErrInvalidWeight is the error returned when acquiring less than one slot
+var ErrInvalidWeight = errors.New("weight must be at least 1")
 
 type Limiter struct {
-	mu      sync.Mutex
-	waiters priorityQueue
-	stage   stage
+	mu        sync.Mutex
+	waiters   priorityQueue
+	algorithm LimitAlgorithm
 
-	acksLeft    int
 	outstanding int
 	limit       int
+	minLimit    int
 	maxLimit    int
+
+	// started holds the admission time of each outstanding slot, oldest first
+	started []time.Time
+	// idleSince is when the last outstanding token was released
+	idleSince time.Time
+
+	// pausedUntil is when to resume admitting waiters after BackoffUntil
+	pausedUntil time.Time
+	pauseTimer  *time.Timer
+
+	// fair is set when waiters are queued round robin by tenant
+	fair *fairQueue
+	// edf is set when waiters are queued by deadline
+	edf bool
+	// service is the average time tokens are held, in nanoseconds
+	service ewma
+	// lifoThreshold is the queue delay that switches waiters to newest first
+	lifoThreshold time.Duration
+	// codel is set when waiters are dropped once the queue delay stays high
+	codel *codel
+
+	// granted are Reservations admitted while the lock was held, which
+	// move on to the next Limiter once it is unlocked
+	granted []*Reservation
+
+	counters counters
+	observer Observer
+	parent   *Limiter
 }
 
+// New creates a Limiter from the Config, filling in defaults for any
+// unset fields. It does not validate the Config, use NewLimiter for that.
 func New(cfg Config) Limiter {
+	cfg = cfg.withDefaults()
+
+	var algorithm LimitAlgorithm
+	if cfg.Algorithm != nil {
+		algorithm = cfg.Algorithm()
+	} else {
+		algorithm = newAIMD(cfg)
+	}
+
+	var fair *fairQueue
+	if cfg.FairQueuing {
+		fair = &fairQueue{}
+	}
+
+	var queueManagement *codel
+	if cfg.CoDelTarget > 0 {
+		queueManagement = &codel{
+			target:   cfg.CoDelTarget,
+			interval: cfg.CoDelInterval,
+		}
+	}
+
+	waiters := newQueue(cfg.Capacity)
+	waiters.policy = cfg.DropPolicy
+
 	return Limiter{
-		stage:    slowStart,
-		limit:    1,
-		acksLeft: 1,
-		maxLimit: cfg.MaxLimit,
-		waiters:  newQueue(cfg.Capacity),
+		algorithm:     algorithm,
+		limit:         cfg.InitialLimit,
+		minLimit:      cfg.MinLimit,
+		maxLimit:      cfg.MaxLimit,
+		waiters:       waiters,
+		fair:          fair,
+		edf:           cfg.EarliestDeadlineFirst,
+		lifoThreshold: cfg.LIFOThreshold,
+		codel:         queueManagement,
+		observer:      cfg.Observer,
+		parent:        cfg.Parent,
 	}
 }
 
+// NewLimiter validates the Config, and creates a Limiter from it.
+func NewLimiter(cfg Config) (*Limiter, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	l := New(cfg)
+	return &l, nil
+}
+
 // Acquire a Lock with FIFO ordering, respecting the context. Returns an error it fails to acquire.
+// Waiters are admitted highest priority first, and in the order they
+// arrived within a priority. If the Limiter has a parent, that is
+// acquired too, at the same priority.
 func (l *Limiter) Acquire(ctx context.Context, priority int) error {
+	return l.AcquireN(ctx, priority, 1)
+}
+
+// AcquireN acquires n slots at once, for requests that cost more than
+// others. It must be released with ReleaseN(n). Waiters are admitted in
+// order, so a large request at the head of the queue holds back smaller
+// ones behind it until enough slots are free. A request larger than the
+// limit is admitted once nothing else is outstanding.
+func (l *Limiter) AcquireN(ctx context.Context, priority int, n int) error {
+	if n < 1 {
+		return ErrInvalidWeight
+	}
+
+	err := l.acquire(ctx, priority, n)
+	if err != nil || l.parent == nil {
+		return err
+	}
+
+	err = l.parent.AcquireN(ctx, priority, n)
+	if err != nil {
+		l.abandon(n)
+	}
+
+	return err
+}
+
+// acquire n slots from this Limiter, ignoring any parent.
+func (l *Limiter) acquire(ctx context.Context, priority int, n int) error {
 	l.mu.Lock()
 
 	// Fast path if we are unblocked.
-	if l.outstanding < l.limit && l.waiters.Len() == 0 {
-		l.outstanding++
+	if l.fastPath(n) {
 		l.mu.Unlock()
+
+		if l.observer != nil {
+			l.observe(ctx, priority, time.Now(), AcquireFast, false, nil)
+		}
 		return nil
 	}
 
+	start := time.Now()
+
 	r := rendezvouz{
 		priority: priority,
-		errChan:  make(chan error),
+		n:        n,
+		enqueued: start,
+		errChan:  make(chan error, 1),
 	}
 
-	pushed := l.waiters.Push(&r)
+	if l.edf {
+		r.deadline, _ = ctx.Deadline()
+	}
+
+	var tenant string
+	if l.fair != nil {
+		tenant, _ = TenantFromContext(ctx)
+	}
+
+	err := l.enqueue(&r, tenant)
 	l.mu.Unlock()
 
-	if !pushed {
-		return Dropped
+	if err != nil {
+		l.observe(ctx, priority, start, AcquireDropped, false, err)
+		return err
 	}
 
 	select {
 
 	case err := <-r.errChan:
+		l.observe(ctx, priority, start, queuedOutcome(err), true, err)
 		return err
 
 	case <-ctx.Done():
 		err := ctx.Err()
+		outcome := AcquireCanceled
 
 		l.mu.Lock()
 
 		select {
 		case err = <-r.errChan:
+			outcome = queuedOutcome(err)
 		default:
-			l.waiters.Remove(&r)
+			l.withdraw(&r)
 		}
 
 		l.mu.Unlock()
 
+		l.observe(ctx, priority, start, outcome, true, err)
 		return err
 	}
 }
 
-func (l *Limiter) ack() {
-	// If we are waiting on acks, decrement and move on
-	if l.stage == recovering {
-		l.acksLeft = l.limit
-		// Implement a waiting period of our limit before scaling again
-		l.stage = waiting
-		return
+// fastPath admits n slots if it can be done without waiting, returning
+// if they were. It must be called with the lock held.
+func (l *Limiter) fastPath(n int) bool {
+	if !l.unblocked(n) {
+		return false
 	}
 
-	if l.acksLeft > 1 {
-		l.acksLeft--
-		return
+	if l.codel != nil {
+		l.codel.admitted(time.Now(), 0, 0)
+	}
+	l.admit(n)
+	l.counters.fastPath++
+
+	return true
+}
+
+// enqueue queues the waiter, or returns why it can't be. It must be
+// called with the lock held.
+func (l *Limiter) enqueue(r *rendezvouz, tenant string) error {
+	if l.fair != nil {
+		l.fair.schedule(r, tenant)
+	}
+
+	// Don't queue a waiter that will time out anyway
+	if l.edf && l.late(r.deadline, l.ahead(r)) {
+		l.counters.drops++
+		return ErrDeadline
+	}
+
+	// If the queue is full, either this or another waiter is dropped
+	if l.waiters.Len() >= l.waiters.Cap() {
+		l.counters.drops++
+	}
+
+	if !l.waiters.Push(r) {
+		return &DropError{Reason: Rejected, Priority: r.priority}
+	}
+
+	if l.fair != nil {
+		l.fair.queued(r)
+	}
+
+	return nil
+}
+
+// withdraw takes a waiter that gave up out of the queue. It must be
+// called with the lock held.
+func (l *Limiter) withdraw(r *rendezvouz) {
+	l.waiters.Remove(r)
+	l.counters.cancellations++
+
+	if l.fair != nil {
+		l.fair.removed(r)
+	}
+}
+
+// queuedOutcome is the outcome of a waiter that was handed an error from the queue
+func queuedOutcome(err error) AcquireOutcome {
+	if err != nil {
+		return AcquireDropped
+	}
+	return AcquireQueued
+}
+
+// setLimit applies a limit returned by the algorithm, keeping it within bounds.
+func (l *Limiter) setLimit(limit int) {
+	if limit > l.maxLimit {
+		limit = l.maxLimit
+	}
+	if limit < l.minLimit {
+		limit = l.minLimit
 	}
+	if limit < 1 {
+		limit = 1
+	}
+	l.limit = limit
+}
+
+// fits returns if n more slots can be admitted under the limit. When
+// nothing is outstanding anything fits, so requests larger than the
+// limit still make progress.
+func (l *Limiter) fits(n int) bool {
+	return l.outstanding+n <= l.limit || (l.outstanding == 0 && l.limit > 0)
+}
+
+// unblocked returns if n slots can be admitted without waiting.
+func (l *Limiter) unblocked(n int) bool {
+	return l.fits(n) && l.waiters.Len() == 0 && !l.paused()
+}
 
-	switch l.stage {
+// admit n slots, recording when they started.
+func (l *Limiter) admit(n int) {
+	now := time.Now()
 
-	case waiting:
-		if l.outstanding == l.limit {
-			l.stage = increasing
+	if l.outstanding == 0 && !l.idleSince.IsZero() {
+		if r, ok := l.algorithm.(Restarter); ok {
+			l.setLimit(r.Restart(l.sample(), now.Sub(l.idleSince)))
 		}
+	}
 
-	// If we're in slow start, double our limit
-	case slowStart:
-		l.limit = l.limit * 2
+	l.outstanding += n
+	l.counters.acquisitions++
+	for i := 0; i < n; i++ {
+		l.started = append(l.started, now)
+	}
+}
 
-	// If we're increasing increment
-	case increasing:
-		l.limit++
+// rtt estimates how long the n slots being released were held. Tokens
+// are anonymous, so this pairs each release with the oldest
+// outstanding admissions. Individual samples are noisy, but their sum,
+// and so their mean, matches the true hold times.
+func (l *Limiter) rtt(n int) time.Duration {
+	if len(l.started) == 0 {
+		return 0
 	}
 
-	if l.limit > l.maxLimit {
-		l.limit = l.maxLimit
+	if n > len(l.started) {
+		n = len(l.started)
 	}
 
-	// reset acks left for next stage transition
-	l.acksLeft = l.limit
+	start := l.started[0]
+	l.started = l.started[n:]
+	return time.Since(start)
+}
+
+func (l *Limiter) sample() Sample {
+	return Sample{
+		Limit:    l.limit,
+		MinLimit: l.minLimit,
+		MaxLimit: l.maxLimit,
+		Inflight: l.outstanding,
+	}
 }
 
 // Release a previously acquired lock.
 func (l *Limiter) Release() {
-	l.mu.Lock()
-
-	l.ack()
+	l.ReleaseN(1)
+}
 
-	l.outstanding--
+// ReleaseN releases n slots acquired with AcquireN.
+func (l *Limiter) ReleaseN(n int) {
+	l.mu.Lock()
 
-	if l.outstanding < 0 {
+	if n < 1 || l.outstanding < n {
 		l.mu.Unlock()
 		panic("lock: bad release")
 	}
 
-	for !l.waiters.Empty() && l.outstanding < l.limit {
+	s := l.sample()
+	s.RTT = l.rtt(n)
+	s.Weight = n
+	l.setLimit(l.algorithm.Success(s))
+	l.served(s.RTT)
+
+	l.release(n)
+
+	l.unlock()
+
+	if l.parent != nil {
+		l.parent.ReleaseN(n)
+	}
+}
+
+// release n outstanding slots, handing their capacity to any waiters.
+// Returns false if there were not that many outstanding.
+func (l *Limiter) release(n int) bool {
+	if l.outstanding < n {
+		return false
+	}
+
+	l.outstanding -= n
+	if l.outstanding == 0 {
+		l.idleSince = time.Now()
+	}
+
+	l.dispatch()
+
+	return true
+}
+
+// dispatch admits waiters while there is capacity under the limit. It
+// stops at the first waiter that doesn't fit, so large requests aren't
+// starved by smaller ones behind them.
+func (l *Limiter) dispatch() {
+	if l.paused() {
+		return
+	}
+
+	for !l.waiters.Empty() && l.fits(l.waiters.Peek().n) {
 		rendezvouz := l.waiters.Pop()
 
-		l.outstanding++
+		if l.fair != nil {
+			l.fair.admitted(&rendezvouz, l.waiters.Len())
+		}
+
+		if l.edf && l.late(rendezvouz.deadline, rendezvouz.n) {
+			l.counters.drops++
+			rendezvouz.fail(ErrDeadline)
+			continue
+		}
+
+		if l.lifoThreshold > 0 {
+			l.adaptOrder(time.Since(rendezvouz.enqueued))
+		}
+
+		if l.codel != nil {
+			now := time.Now()
+			if l.codel.admitted(now, now.Sub(rendezvouz.enqueued), l.waiters.Len()) {
+				l.waiters.DropLowest()
+				l.counters.drops++
+			}
+		}
+
+		l.admit(rendezvouz.n)
 		rendezvouz.Signal()
+
+		if rendezvouz.reservation != nil {
+			l.granted = append(l.granted, rendezvouz.reservation)
+		}
 	}
+}
+
+// unlock the Limiter, and then move any Reservations admitted while it
+// was locked on to their next Limiter. Anything that can dispatch must
+// unlock with this.
+func (l *Limiter) unlock() {
+	granted := l.granted
+	l.granted = nil
 
 	l.mu.Unlock()
-}
 
-func (l *Limiter) decrease() {
-	l.limit = (l.limit * 3) / 4
-	if l.limit < 1 {
-		l.limit = 1
+	for _, r := range granted {
+		r.granted()
 	}
-	l.acksLeft = l.limit
+}
 
+// backoff signals the algorithm to decrease the limit.
+func (l *Limiter) backoff(s Sample) {
+	l.counters.backoffs++
+	l.setLimit(l.algorithm.Backoff(s))
 }
 
 // Signal that we need to backoff, and decrease our limit.
 func (l *Limiter) Backoff() {
 	l.mu.Lock()
+	l.backoff(l.sample())
+	l.mu.Unlock()
+}
 
-	switch l.stage {
-
-	// Decrease limit if we were not recovering
-	case slowStart:
-		l.decrease()
-	case waiting:
-		l.decrease()
-	case increasing:
-		l.decrease()
-
-	// If we are recovering for more than the ack period, we decrease the limit again
-	case recovering:
-		if l.acksLeft > 1 {
-			l.acksLeft--
-		} else {
-			l.decrease()
-		}
+// SetMaxLimit changes the largest the limit can grow. If the limit was
+// held at the old maximum it is raised to the new one, admitting
+// waiters immediately, otherwise the algorithm grows into it.
+func (l *Limiter) SetMaxLimit(maxLimit int) {
+	l.mu.Lock()
+
+	if l.limit >= l.maxLimit {
+		l.limit = maxLimit
 	}
 
-	l.stage = recovering
+	l.maxLimit = maxLimit
+	l.setLimit(l.limit)
+	l.dispatch()
+
+	l.unlock()
+}
+
+// SetCapacity changes the number of waiters that can be queued. If
+// there are more waiters than the new capacity, the lowest priority
+// ones are dropped.
+func (l *Limiter) SetCapacity(capacity int) {
+	l.mu.Lock()
+
+	queued := l.waiters.Len()
+	l.waiters.SetCap(capacity)
+	l.counters.drops += uint64(queued - l.waiters.Len())
 
 	l.mu.Unlock()
 }
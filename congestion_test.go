@@ -2,32 +2,37 @@ package congestion
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestRelease(t *testing.T) {
 	cases := []struct {
-		Stage         stage
+		Stage         Stage
 		AcksLeft      int
 		Outstanding   int
 		Limit         int
 		Expected      int
-		ExpectedStage stage
+		ExpectedStage Stage
 	}{
-		{recovering, 1, 10, 10, 10, waiting},
-		{waiting, 2, 10, 10, 10, waiting},
-		{waiting, 1, 10, 10, 10, increasing},
-		{waiting, 1, 9, 10, 10, waiting},
-		{slowStart, 2, 10, 10, 10, slowStart},
-		{slowStart, 1, 10, 10, 20, slowStart},
-		{increasing, 2, 10, 10, 10, increasing},
-		{increasing, 1, 10, 10, 11, increasing},
+		{Recovering, 1, 10, 10, 10, Waiting},
+		{Waiting, 2, 10, 10, 10, Waiting},
+		{Waiting, 1, 10, 10, 10, Increasing},
+		{Waiting, 1, 9, 10, 10, Waiting},
+		{SlowStart, 2, 10, 10, 10, SlowStart},
+		{SlowStart, 1, 10, 10, 20, SlowStart},
+		{Increasing, 2, 10, 10, 10, Increasing},
+		{Increasing, 1, 10, 10, 11, Increasing},
 	}
 
 	for _, tc := range cases {
+		a := newAIMD(Config{})
+		a.stage = tc.Stage
+		a.acksLeft = tc.AcksLeft
+
 		l := Limiter{
-			stage:       tc.Stage,
-			acksLeft:    tc.AcksLeft,
+			algorithm:   a,
 			outstanding: tc.Outstanding,
 			limit:       tc.Limit,
 			maxLimit:    1000,
@@ -36,7 +41,7 @@ func TestRelease(t *testing.T) {
 		l.Release()
 
 		actual := l.limit
-		actualStage := l.stage
+		actualStage := a.stage
 
 		if actual != tc.Expected || actualStage != tc.ExpectedStage {
 			t.Errorf("Ack %s acksLeft=%d limit=%d is %d %s, expected %d %s", tc.Stage, tc.AcksLeft, tc.Limit, actual, actualStage, tc.Expected, tc.ExpectedStage)
@@ -46,27 +51,30 @@ func TestRelease(t *testing.T) {
 
 func TestRateBackoff(t *testing.T) {
 	cases := []struct {
-		Stage    stage
+		Stage    Stage
 		AcksLeft int
 		Limit    int
 		Expected int
 	}{
-		{recovering, 2, 100, 100},
-		{recovering, 1, 100, 75},
-		{waiting, 1, 100, 75},
-		{slowStart, 1, 100, 75},
-		{increasing, 1, 100, 75},
-		{increasing, 1, 10, 7},
-		{increasing, 1, 2, 1},
-		{increasing, 1, 1, 1},
+		{Recovering, 2, 100, 100},
+		{Recovering, 1, 100, 75},
+		{Waiting, 1, 100, 75},
+		{SlowStart, 1, 100, 75},
+		{Increasing, 1, 100, 75},
+		{Increasing, 1, 10, 7},
+		{Increasing, 1, 2, 1},
+		{Increasing, 1, 1, 1},
 	}
 
 	for _, tc := range cases {
+		a := newAIMD(Config{})
+		a.stage = tc.Stage
+		a.acksLeft = tc.AcksLeft
+
 		l := Limiter{
-			stage:    tc.Stage,
-			acksLeft: tc.AcksLeft,
-			limit:    tc.Limit,
-			maxLimit: 1000,
+			algorithm: a,
+			limit:     tc.Limit,
+			maxLimit:  1000,
 		}
 
 		l.Backoff()
@@ -80,8 +88,133 @@ func TestRateBackoff(t *testing.T) {
 
 }
 
+func TestSlowStartThreshold(t *testing.T) {
+	cases := []struct {
+		Stage         Stage
+		Ssthresh      int
+		Outstanding   int
+		Limit         int
+		Expected      int
+		ExpectedStage Stage
+	}{
+		// Below the threshold after recovering, slow start back up
+		{Waiting, 10, 7, 7, 7, SlowStart},
+		{Waiting, 7, 7, 7, 7, Increasing},
+		// Slow start stops at the threshold
+		{SlowStart, 10, 7, 7, 10, Increasing},
+		{SlowStart, 20, 7, 7, 14, SlowStart},
+		// Already past the threshold, so increase carefully
+		{SlowStart, 5, 7, 7, 8, Increasing},
+	}
+
+	for _, tc := range cases {
+		a := newAIMD(Config{})
+		a.stage = tc.Stage
+		a.acksLeft = 1
+		a.ssthresh = tc.Ssthresh
+
+		l := Limiter{
+			algorithm:   a,
+			outstanding: tc.Outstanding,
+			limit:       tc.Limit,
+			maxLimit:    1000,
+		}
+
+		l.Release()
+
+		if l.limit != tc.Expected || a.stage != tc.ExpectedStage {
+			t.Errorf("Ack %s ssthresh=%d limit=%d is %d %s, expected %d %s", tc.Stage, tc.Ssthresh, tc.Limit, l.limit, a.stage, tc.Expected, tc.ExpectedStage)
+		}
+	}
+}
+
+func TestBackoffSetsSlowStartThreshold(t *testing.T) {
+	a := newAIMD(Config{})
+	a.stage = Increasing
+
+	l := Limiter{
+		algorithm: a,
+		limit:     100,
+		maxLimit:  1000,
+	}
+
+	l.Backoff()
+
+	if a.ssthresh != 100 {
+		t.Errorf("Got ssthresh %d, expected %d", a.ssthresh, 100)
+	}
+}
+
+func TestIdleRestart(t *testing.T) {
+	c := New(Config{
+		Capacity:     10,
+		MaxLimit:     100,
+		InitialLimit: 2,
+		IdleTimeout:  time.Millisecond,
+	})
+	c.limit = 50
+
+	a := c.algorithm.(*aimd)
+	a.stage = Increasing
+
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	c.Release()
+
+	time.Sleep(2 * time.Millisecond)
+
+	err = c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	defer c.Release()
+
+	if c.limit != 2 || a.stage != SlowStart || a.ssthresh != 50 {
+		t.Errorf("Got limit=%d stage=%s ssthresh=%d, expected limit=%d stage=%s ssthresh=%d", c.limit, a.stage, a.ssthresh, 2, SlowStart, 50)
+	}
+}
+
+// fixedAlgorithm always returns the same limits
+type fixedAlgorithm struct {
+	success int
+	backoff int
+}
+
+func (f fixedAlgorithm) Success(s Sample) int { return f.success }
+func (f fixedAlgorithm) Backoff(s Sample) int { return f.backoff }
+
+func TestLimiterUsesAlgorithm(t *testing.T) {
+	c := New(Config{
+		Capacity: 10,
+		MaxLimit: 10,
+		Algorithm: func() LimitAlgorithm {
+			return fixedAlgorithm{success: 20, backoff: 0}
+		},
+	})
+
+	err := c.Acquire(context.Background(), 100)
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+	c.Release()
+
+	if c.limit != 10 {
+		t.Errorf("Got limit %d after success, expected it clamped to %d", c.limit, 10)
+	}
+
+	c.Backoff()
+
+	if c.limit != 1 {
+		t.Errorf("Got limit %d after backoff, expected it clamped to %d", c.limit, 1)
+	}
+}
+
 func TestLimiter(t *testing.T) {
-	c := New(Config{10, 10})
+	c := New(Config{Capacity: 10, MaxLimit: 10})
 
 	err := c.Acquire(context.Background(), 100)
 	if err != nil {
@@ -92,7 +225,7 @@ func TestLimiter(t *testing.T) {
 }
 
 func TestAcquireFailsForCanceledContext(t *testing.T) {
-	c := New(Config{10, 10})
+	c := New(Config{Capacity: 10, MaxLimit: 10})
 	c.limit = 0
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -109,7 +242,7 @@ func TestAcquireFailsForCanceledContext(t *testing.T) {
 func TestLimiterCanHaveMultiple(t *testing.T) {
 	const concurrent = 4
 
-	c := New(Config{10, 10})
+	c := New(Config{Capacity: 10, MaxLimit: 10})
 	c.limit = concurrent
 
 	ctx := context.Background()
@@ -127,9 +260,223 @@ func TestLimiterCanHaveMultiple(t *testing.T) {
 	}
 }
 
+// waitForQueued blocks until the limiter has n waiters queued
+func waitForQueued(c *Limiter, n int) {
+	for {
+		c.mu.Lock()
+		queued := c.waiters.Len()
+		c.mu.Unlock()
+
+		if queued >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetMaxLimit(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1})
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(ctx, 0)
+	}()
+
+	waitForQueued(&c, 1)
+
+	c.SetMaxLimit(2)
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.SetMaxLimit(1)
+	if c.limit != 1 {
+		t.Errorf("Got limit %d, expected %d", c.limit, 1)
+	}
+
+	c.Release()
+	c.Release()
+}
+
+func TestSetCapacity(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1})
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(ctx, 0)
+	}()
+
+	waitForQueued(&c, 1)
+
+	c.SetCapacity(0)
+
+	err = <-done
+	if !errors.Is(err, Dropped) {
+		t.Errorf("Got %v, expected %v", err, Dropped)
+	}
+
+	err = c.Acquire(ctx, 0)
+	if !errors.Is(err, Dropped) {
+		t.Errorf("Got %v, expected %v", err, Dropped)
+	}
+
+	c.Release()
+}
+
+func TestAcquireN(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 10, InitialLimit: 4})
+	ctx := context.Background()
+
+	err := c.AcquireN(ctx, 0, 3)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if c.outstanding != 3 {
+		t.Errorf("Got %d outstanding, expected %d", c.outstanding, 3)
+	}
+
+	err = c.AcquireN(ctx, 0, 0)
+	if err != ErrInvalidWeight {
+		t.Errorf("Got %v, expected %v", err, ErrInvalidWeight)
+	}
+
+	// Only one slot is left, so this waits
+	done := make(chan error)
+	go func() {
+		done <- c.AcquireN(ctx, 0, 2)
+	}()
+
+	waitForQueued(&c, 1)
+
+	c.ReleaseN(3)
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.ReleaseN(2)
+
+	if c.outstanding != 0 || len(c.started) != 0 {
+		t.Errorf("Got %d outstanding, expected %d", c.outstanding, 0)
+	}
+}
+
+func TestAcquireNLargerThanLimit(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 2})
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.AcquireN(ctx, 0, 5)
+	}()
+
+	waitForQueued(&c, 1)
+
+	// It is admitted alone once nothing else is outstanding
+	c.Release()
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.ReleaseN(5)
+}
+
+func TestAcquireNHeadOfLine(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 4, InitialLimit: 4})
+	ctx := context.Background()
+
+	err := c.AcquireN(ctx, 0, 3)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	large := make(chan error)
+	go func() {
+		large <- c.AcquireN(ctx, 0, 4)
+	}()
+	waitForQueued(&c, 1)
+
+	// There is a slot free, but the large request is ahead
+	small := make(chan error)
+	go func() {
+		small <- c.Acquire(ctx, 0)
+	}()
+	waitForQueued(&c, 2)
+
+	select {
+	case <-small:
+		t.Fatal("Small request passed the large one")
+	default:
+	}
+
+	c.ReleaseN(3)
+
+	err = <-large
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.mu.Lock()
+	queued := c.waiters.Len()
+	c.mu.Unlock()
+
+	if queued != 1 {
+		t.Errorf("Got %d queued, expected %d", queued, 1)
+	}
+
+	c.ReleaseN(4)
+
+	err = <-small
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.Release()
+}
+
+func TestWeightedAcks(t *testing.T) {
+	a := newAIMD(Config{})
+	a.stage = Increasing
+	a.acksLeft = 10
+
+	s := Sample{Limit: 10, MaxLimit: 100, Inflight: 10, Weight: 4}
+
+	if limit := a.Success(s); limit != 10 || a.acksLeft != 6 {
+		t.Errorf("Got limit %d with %d acks left, expected %d with %d", limit, a.acksLeft, 10, 6)
+	}
+
+	s.Weight = 6
+	if limit := a.Success(s); limit != 11 {
+		t.Errorf("Got limit %d, expected %d", limit, 11)
+	}
+}
+
 func BenchmarkLimiter(b *testing.B) {
 	b.Run("Unblocked", func(b *testing.B) {
-		c := New(Config{10, 10})
+		c := New(Config{Capacity: 10, MaxLimit: 10})
 
 		ctx := context.Background()
 		b.ResetTimer()
@@ -148,7 +495,7 @@ func BenchmarkLimiter(b *testing.B) {
 	b.Run("Blocked", func(b *testing.B) {
 		const concurrent = 4
 
-		c := New(Config{10, 10})
+		c := New(Config{Capacity: 10, MaxLimit: 10})
 		c.limit = concurrent
 
 		ctx := context.Background()
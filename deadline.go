@@ -0,0 +1,51 @@
+package congestion
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDeadline is the error returned to waiters that can't be served
+// before their context's deadline, with EarliestDeadlineFirst.
+var ErrDeadline = errors.New("deadline can't be met")
+
+// serviceWindow is the number of releases averaged to estimate how long tokens are held
+const serviceWindow = 100
+
+// served records how long a released token was held.
+func (l *Limiter) served(rtt time.Duration) {
+	if l.edf && rtt > 0 {
+		l.service.add(serviceWindow, float64(rtt))
+	}
+}
+
+// ahead estimates the number of slots queued ahead of the waiter, and
+// its own. Only higher priorities are counted, since ordering within a
+// priority would need a scan of the queue, so this errs towards queueing
+// the waiter. If it turns out to be late it fails once dispatched.
+func (l *Limiter) ahead(r *rendezvouz) int {
+	ahead := r.n
+	for priority, band := range l.waiters.bands {
+		if priority > r.priority {
+			ahead += band.slots
+		}
+	}
+	return ahead
+}
+
+// late returns if a waiter can't be served before its deadline, once
+// the slots ahead of it are admitted. Slots free up at the limit every
+// service time, and then the waiter needs a service time of its own.
+func (l *Limiter) late(deadline time.Time, ahead int) bool {
+	service := time.Duration(l.service.value)
+	if deadline.IsZero() || service <= 0 {
+		return false
+	}
+
+	var wait time.Duration
+	if excess := l.outstanding + ahead - l.limit; excess > 0 && l.limit > 0 {
+		wait = service * time.Duration(excess) / time.Duration(l.limit)
+	}
+
+	return time.Now().Add(wait + service).After(deadline)
+}
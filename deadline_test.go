@@ -0,0 +1,166 @@
+package congestion
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadlineOrdering(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1, EarliestDeadlineFirst: true})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	order := make(chan string, 3)
+	acquire := func(name string, ctx context.Context) {
+		err := c.Acquire(ctx, 0)
+		if err != nil {
+			t.Error("Got an error:", err)
+		}
+		order <- name
+	}
+
+	later, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sooner, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	go acquire("none", context.Background())
+	waitForQueued(&c, 1)
+	go acquire("later", later)
+	waitForQueued(&c, 2)
+	go acquire("sooner", sooner)
+	waitForQueued(&c, 3)
+
+	for _, expected := range []string{"sooner", "later", "none"} {
+		c.Release()
+
+		if name := <-order; name != expected {
+			t.Errorf("Got %s, expected %s", name, expected)
+		}
+	}
+
+	c.Release()
+}
+
+func TestDeadlineUnreachable(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1, EarliestDeadlineFirst: true})
+	c.service.add(serviceWindow, float64(time.Second))
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// It would take a second to be admitted, so this fails without queueing
+	err = c.Acquire(ctx, 0)
+	if err != ErrDeadline {
+		t.Errorf("Got %v, expected %v", err, ErrDeadline)
+	}
+
+	if s := c.Stats(); s.Queued != 0 || s.Drops != 1 {
+		t.Errorf("Got %d queued and %d drops, expected %d and %d", s.Queued, s.Drops, 0, 1)
+	}
+
+	c.Release()
+}
+
+func TestDeadlineCountsHigherPriorities(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1, EarliestDeadlineFirst: true})
+	c.service.add(serviceWindow, float64(100*time.Millisecond))
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		go c.Acquire(ctx, 1)
+		waitForQueued(&c, i+1)
+	}
+
+	deadline, cancelDeadline := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancelDeadline()
+
+	// Behind the higher priorities, it can't be served in time
+	err = c.Acquire(deadline, 0)
+	if err != ErrDeadline {
+		t.Errorf("Got %v, expected %v", err, ErrDeadline)
+	}
+
+	// Ahead of them, it can
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(deadline, 2)
+	}()
+
+	waitForQueued(&c, 4)
+	c.Release()
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.Release()
+}
+
+func TestDeadlineFailsWhenDispatched(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1, EarliestDeadlineFirst: true})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(ctx, 0)
+	}()
+
+	waitForQueued(&c, 1)
+
+	// Tokens are now held longer than the waiter has left
+	c.mu.Lock()
+	c.service.add(serviceWindow, float64(time.Hour))
+	c.mu.Unlock()
+
+	c.Release()
+
+	err = <-done
+	if err != ErrDeadline {
+		t.Errorf("Got %v, expected %v", err, ErrDeadline)
+	}
+
+	if s := c.Stats(); s.Outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 0)
+	}
+}
+
+func TestServiceTime(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1, EarliestDeadlineFirst: true})
+
+	token, err := c.AcquireToken(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	token.Success()
+
+	if service := time.Duration(c.service.value); service < 10*time.Millisecond {
+		t.Errorf("Got service time %s, expected at least %s", service, 10*time.Millisecond)
+	}
+}
@@ -0,0 +1,68 @@
+package congestion
+
+import "fmt"
+
+// DropPolicy decides which waiter is dropped when one arrives at a full queue.
+type DropPolicy int
+
+const (
+	// DropLowestPriority evicts the waiter that would be served last, if
+	// the new waiter would be served before it. This is the default.
+	DropLowestPriority DropPolicy = iota
+	// DropNewest evicts the most recently queued waiter of the new
+	// waiter's priority or lower.
+	DropNewest
+	// DropOldest evicts the longest queued waiter of the new waiter's
+	// priority or lower, which has likely been given up on.
+	DropOldest
+	// RejectIncoming never evicts, and drops the new waiter instead.
+	RejectIncoming
+)
+
+// DropReason is why a waiter was dropped.
+type DropReason int
+
+const (
+	// Rejected waiters were never queued, because the queue was full.
+	Rejected = DropReason(iota + 1)
+	// Evicted waiters were queued, and made room for a new waiter.
+	Evicted
+	// Shed waiters were queued, and dropped to shrink the queue, e.g.
+	// by SetCapacity or CoDel.
+	Shed
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case Rejected:
+		return "rejected"
+	case Evicted:
+		return "evicted"
+	case Shed:
+		return "shed"
+	}
+	return "unknown"
+}
+
+// DropError is the error returned to a waiter that was dropped. It
+// matches Dropped with errors.Is.
+type DropError struct {
+	Reason DropReason
+	// Priority is the dropped waiter's priority
+	Priority int
+	// DisplacedBy is the priority of the waiter that took its place,
+	// when it was Evicted.
+	DisplacedBy int
+}
+
+func (e *DropError) Error() string {
+	if e.Reason == Evicted {
+		return fmt.Sprintf("%s: %s priority %d for priority %d", Dropped, e.Reason, e.Priority, e.DisplacedBy)
+	}
+	return fmt.Sprintf("%s: %s priority %d", Dropped, e.Reason, e.Priority)
+}
+
+// Is reports the error as Dropped.
+func (e *DropError) Is(target error) bool {
+	return target == Dropped
+}
@@ -0,0 +1,122 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDropError(t *testing.T) {
+	var err error = &DropError{Reason: Evicted, Priority: 1, DisplacedBy: 3}
+
+	if !errors.Is(err, Dropped) {
+		t.Errorf("Expected %v to be %v", err, Dropped)
+	}
+
+	if s := err.Error(); s != "dropped: evicted priority 1 for priority 3" {
+		t.Errorf("Got %q", s)
+	}
+
+	err = &DropError{Reason: Rejected, Priority: 2}
+	if s := err.Error(); s != "dropped: rejected priority 2" {
+		t.Errorf("Got %q", s)
+	}
+}
+
+// dropError returns the error the waiter was dropped with, if any
+func dropError(r *rendezvouz) *DropError {
+	select {
+	case err := <-r.errChan:
+		var drop *DropError
+		errors.As(err, &drop)
+		return drop
+	default:
+		return nil
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	cases := []struct {
+		Policy   DropPolicy
+		Incoming int
+		// Evicted is the index of the queued waiter that is evicted, or -1
+		Evicted int
+	}{
+		{DropLowestPriority, 1, 2},
+		{DropLowestPriority, 0, -1},
+		{DropNewest, 0, 2},
+		{DropNewest, 1, 3},
+		{DropOldest, 0, 1},
+		{DropOldest, 2, 0},
+		{DropNewest, -1, -1},
+		{RejectIncoming, 5, -1},
+	}
+
+	for _, tc := range cases {
+		// Priorities 2, 0, 0, 1 queued in that order
+		rs := []rendezvouz{{priority: 2}, {priority: 0}, {priority: 0}, {priority: 1}}
+
+		q := newQueue(len(rs))
+		q.policy = tc.Policy
+
+		for i := range rs {
+			rs[i].errChan = make(chan error, 1)
+			q.Push(&rs[i])
+		}
+
+		incoming := rendezvouz{priority: tc.Incoming, errChan: make(chan error, 1)}
+		pushed := q.Push(&incoming)
+
+		if pushed != (tc.Evicted >= 0) {
+			t.Errorf("Policy %d priority %d got pushed %t", tc.Policy, tc.Incoming, pushed)
+		}
+
+		for i := range rs {
+			err := dropError(&rs[i])
+
+			if i != tc.Evicted {
+				if err != nil {
+					t.Errorf("Policy %d priority %d dropped %d: %v", tc.Policy, tc.Incoming, i, err)
+				}
+				continue
+			}
+
+			expected := DropError{Reason: Evicted, Priority: rs[i].priority, DisplacedBy: tc.Incoming}
+			if err == nil || *err != expected {
+				t.Errorf("Policy %d priority %d got %v for %d, expected %v", tc.Policy, tc.Incoming, err, i, &expected)
+			}
+		}
+	}
+}
+
+func TestLimiterDropErrors(t *testing.T) {
+	c := New(Config{Capacity: 1, MaxLimit: 1, DropPolicy: RejectIncoming})
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(ctx, 0)
+	}()
+	waitForQueued(&c, 1)
+
+	err = c.Acquire(ctx, 7)
+
+	var drop *DropError
+	if !errors.As(err, &drop) || *drop != (DropError{Reason: Rejected, Priority: 7}) {
+		t.Errorf("Got %v, expected a rejected drop", err)
+	}
+
+	c.SetCapacity(0)
+
+	err = <-done
+	if !errors.As(err, &drop) || *drop != (DropError{Reason: Shed, Priority: 0}) {
+		t.Errorf("Got %v, expected a shed drop", err)
+	}
+
+	c.Release()
+}
@@ -0,0 +1,97 @@
+package congestion
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a context carrying the tenant for requests made
+// with it. Limiters with FairQueuing serve tenants round robin.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, if any
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// tenantPriority identifies a tenant's waiters at one priority
+type tenantPriority struct {
+	tenant   string
+	priority int
+}
+
+// fairQueue assigns waiters a round, so that within a priority each
+// tenant's first waiter is served before any tenant's second, and so on.
+// Rounds are counted in slots, so heavier waiters use up more rounds.
+type fairQueue struct {
+	// last is the last round given to each tenant's waiters
+	last map[tenantPriority]uint64
+	// served is the round of the last waiter admitted at each priority
+	served map[int]uint64
+}
+
+// schedule gives the waiter the next round for its tenant, but never
+// one that has already been served, so idle tenants can't bank rounds.
+// The round isn't used up until the waiter is queued.
+func (f *fairQueue) schedule(r *rendezvouz, tenant string) {
+	if f.last == nil {
+		f.last = map[tenantPriority]uint64{}
+		f.served = map[int]uint64{}
+	}
+
+	key := tenantPriority{tenant, r.priority}
+
+	round := f.served[r.priority]
+	if last, ok := f.last[key]; ok && last >= round {
+		round = last + 1
+	}
+
+	r.round = round
+	r.tenant = tenant
+}
+
+// queued uses up the waiter's rounds, once it has been pushed.
+func (f *fairQueue) queued(r *rendezvouz) {
+	f.last[tenantPriority{r.tenant, r.priority}] = r.round + uint64(r.n) - 1
+}
+
+// removed gives back the rounds of a waiter that left the queue without
+// being served, unless its tenant has queued another waiter since.
+func (f *fairQueue) removed(r *rendezvouz) {
+	key := tenantPriority{r.tenant, r.priority}
+
+	if last, ok := f.last[key]; !ok || last != r.round+uint64(r.n)-1 {
+		return
+	}
+
+	if r.round == 0 {
+		delete(f.last, key)
+	} else {
+		f.last[key] = r.round - 1
+	}
+}
+
+// admitted advances the waiter's priority to its round. queued is how
+// many waiters are left, and once it is empty the rounds are forgotten.
+func (f *fairQueue) admitted(r *rendezvouz, queued int) {
+	if queued == 0 {
+		f.last = nil
+		f.served = nil
+		return
+	}
+
+	if r.round > f.served[r.priority] {
+		f.served[r.priority] = r.round
+	}
+
+	// Tenants whose last round has been served are the same as new ones
+	if len(f.last) > 2*queued {
+		for key, last := range f.last {
+			if last < f.served[key.priority] {
+				delete(f.last, key)
+			}
+		}
+	}
+}
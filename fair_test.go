@@ -0,0 +1,189 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTenantFromContext(t *testing.T) {
+	_, ok := TenantFromContext(context.Background())
+	if ok {
+		t.Error("Expected no tenant")
+	}
+
+	tenant, ok := TenantFromContext(WithTenant(context.Background(), "a"))
+	if !ok || tenant != "a" {
+		t.Errorf("Got tenant %q, expected %q", tenant, "a")
+	}
+}
+
+func TestFairQueueRounds(t *testing.T) {
+	f := fairQueue{}
+
+	schedule := func(tenant string, priority, n int) uint64 {
+		r := rendezvouz{priority: priority, n: n}
+		f.schedule(&r, tenant)
+		f.queued(&r)
+		return r.round
+	}
+
+	cases := []struct {
+		Tenant   string
+		Priority int
+		N        int
+		Expected uint64
+	}{
+		{"a", 0, 1, 0},
+		{"a", 0, 1, 1},
+		{"a", 0, 1, 2},
+		{"b", 0, 1, 0},
+		{"b", 0, 2, 1},
+		{"b", 0, 1, 3},
+		{"a", 1, 1, 0},
+	}
+
+	for _, tc := range cases {
+		if round := schedule(tc.Tenant, tc.Priority, tc.N); round != tc.Expected {
+			t.Errorf("Got round %d for %s, expected %d", round, tc.Tenant, tc.Expected)
+		}
+	}
+
+	// A new tenant starts at the last round served, not the first
+	f.admitted(&rendezvouz{priority: 0, round: 2}, 3)
+
+	if round := schedule("c", 0, 1); round != 2 {
+		t.Errorf("Got round %d, expected %d", round, 2)
+	}
+
+	// Everything is forgotten once the queue is empty
+	f.admitted(&rendezvouz{priority: 0, round: 3}, 0)
+
+	if round := schedule("a", 0, 1); round != 0 {
+		t.Errorf("Got round %d, expected %d", round, 0)
+	}
+}
+
+func TestFairQueueRemoved(t *testing.T) {
+	f := fairQueue{}
+
+	a := rendezvouz{priority: 0, n: 1}
+	f.schedule(&a, "a")
+	f.queued(&a)
+
+	// A waiter that isn't queued doesn't use up its round
+	rejected := rendezvouz{priority: 0, n: 1}
+	f.schedule(&rejected, "a")
+
+	removed := rendezvouz{priority: 0, n: 2}
+	f.schedule(&removed, "a")
+	f.queued(&removed)
+
+	if removed.round != 1 {
+		t.Errorf("Got round %d, expected %d", removed.round, 1)
+	}
+
+	// A removed waiter gives its rounds back
+	f.removed(&removed)
+
+	r := rendezvouz{priority: 0, n: 1}
+	f.schedule(&r, "a")
+	f.queued(&r)
+
+	if r.round != 1 {
+		t.Errorf("Got round %d, expected %d", r.round, 1)
+	}
+
+	// Unless a later waiter has been queued
+	f.removed(&a)
+
+	r = rendezvouz{priority: 0, n: 1}
+	f.schedule(&r, "a")
+
+	if r.round != 2 {
+		t.Errorf("Got round %d, expected %d", r.round, 2)
+	}
+}
+
+func TestFairQueuingCancel(t *testing.T) {
+	c := New(Config{Capacity: 4, MaxLimit: 1, FairQueuing: true})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	// Waiters that give up don't push back their tenant's later waiters
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(WithTenant(context.Background(), "a"))
+		done := make(chan error)
+		go func() {
+			done <- c.Acquire(ctx, 0)
+		}()
+		waitForQueued(&c, 1)
+		cancel()
+		<-done
+	}
+
+	c.mu.Lock()
+	_, ok := c.fair.last[tenantPriority{"a", 0}]
+	c.mu.Unlock()
+
+	if ok {
+		t.Error("Expected cancelled waiters to give back their rounds")
+	}
+
+	c.Release()
+}
+
+func TestFairQueuing(t *testing.T) {
+	c := New(Config{Capacity: 4, MaxLimit: 1, FairQueuing: true})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	type result struct {
+		tenant string
+		err    error
+	}
+
+	results := make(chan result, 5)
+	acquire := func(tenant string) {
+		err := c.Acquire(WithTenant(context.Background(), tenant), 0)
+		results <- result{tenant, err}
+	}
+
+	// A noisy tenant fills the queue
+	for i := 0; i < 4; i++ {
+		go acquire("noisy")
+		waitForQueued(&c, i+1)
+	}
+
+	// Another tenant displaces its last waiter instead of being dropped
+	go acquire("quiet")
+
+	r := <-results
+	if r.tenant != "noisy" || !errors.Is(r.err, Dropped) {
+		t.Errorf("Got %s %v, expected %s %v", r.tenant, r.err, "noisy", Dropped)
+	}
+
+	served := []string{}
+	for i := 0; i < 4; i++ {
+		c.Release()
+
+		r := <-results
+		if r.err != nil {
+			t.Fatal("Got an error:", r.err)
+		}
+		served = append(served, r.tenant)
+	}
+
+	c.Release()
+
+	// The quiet tenant shares the first round with the noisy one
+	if served[0] != "quiet" && served[1] != "quiet" {
+		t.Errorf("Got %v, expected quiet to be served in the first round", served)
+	}
+}
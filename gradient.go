@@ -0,0 +1,124 @@
+package congestion
+
+import (
+	"math"
+)
+
+// Gradient2 is a latency based LimitAlgorithm, modeled after Netflix's
+// Gradient2. It compares a short window average of the RTT to a long
+// window average, and scales the limit by their ratio:
+//
+//	gradient = clamp(Tolerance * longRTT / shortRTT, 0.5, 1)
+//	limit = limit * gradient + QueueSize
+//
+// While latency is steady the gradient is 1, and the limit grows by
+// QueueSize. Once requests start queueing upstream the short average
+// rises, and the limit shrinks until it stops.
+type Gradient2 struct {
+	// ShortWindow is the number of samples in the short average
+	ShortWindow int
+	// LongWindow is the number of samples in the long average
+	LongWindow int
+	// Tolerance is how much the short average may exceed the long one before the limit shrinks
+	Tolerance float64
+	// Smoothing is how much weight each new limit gets, between 0 and 1
+	Smoothing float64
+	// QueueSize is how many requests the limit allows to queue upstream
+	QueueSize int
+
+	estimate float64
+	short    ewma
+	long     ewma
+}
+
+// NewGradient2 returns a Gradient2 algorithm with default settings.
+func NewGradient2() *Gradient2 {
+	return &Gradient2{
+		ShortWindow: 10,
+		LongWindow:  600,
+		Tolerance:   1.2,
+		Smoothing:   0.2,
+		QueueSize:   2,
+	}
+}
+
+func (g *Gradient2) sync(limit int) {
+	// Pick up changes to the limit made outside of this algorithm, e.g. clamping
+	if int(g.estimate) != limit {
+		g.estimate = float64(limit)
+	}
+}
+
+func (g *Gradient2) Success(s Sample) int {
+	g.sync(s.Limit)
+
+	if s.RTT <= 0 {
+		return s.Limit
+	}
+
+	rtt := float64(s.RTT)
+	short := g.short.add(g.ShortWindow, rtt)
+	long := g.long.add(g.LongWindow, rtt)
+
+	// If the long average has drifted far above the short, the upstream
+	// has recovered from a period of high latency, so decay it faster.
+	if long/short > 2 {
+		g.long.value = long * 0.95
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.Tolerance*long/short))
+	limit := g.estimate*gradient + float64(g.QueueSize)
+	limit = g.estimate*(1-g.Smoothing) + limit*g.Smoothing
+
+	// Don't grow the limit past what we're using, plus the queue
+	// allowance, but always let it shrink
+	if limit > g.estimate && float64(s.Inflight+g.QueueSize) < g.estimate {
+		return s.Limit
+	}
+
+	if maxLimit := float64(s.MaxLimit); limit > maxLimit {
+		limit = maxLimit
+	}
+	if limit < 1 {
+		limit = 1
+	}
+
+	g.estimate = limit
+	return int(limit)
+}
+
+func (g *Gradient2) Backoff(s Sample) int {
+	g.sync(s.Limit)
+
+	limit := (g.estimate * 3) / 4
+	if limit < 1 {
+		limit = 1
+	}
+
+	g.estimate = limit
+	return int(limit)
+}
+
+// ewma is an exponentially weighted moving average, which is a simple
+// average until it has seen enough samples to warm up.
+type ewma struct {
+	count int
+	value float64
+}
+
+func (e *ewma) add(window int, x float64) float64 {
+	warmup := window
+	if warmup > 10 {
+		warmup = 10
+	}
+
+	if e.count < warmup {
+		e.count++
+		e.value += (x - e.value) / float64(e.count)
+		return e.value
+	}
+
+	factor := 2 / float64(window+1)
+	e.value = e.value*(1-factor) + x*factor
+	return e.value
+}
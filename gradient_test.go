@@ -0,0 +1,104 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGradient2(t *testing.T) {
+	const rtt = 10 * time.Millisecond
+
+	t.Run("It grows while latency is steady", func(t *testing.T) {
+		g := NewGradient2()
+		limit := 10
+
+		for i := 0; i < 100; i++ {
+			limit = g.Success(Sample{Limit: limit, MaxLimit: 1000, Inflight: limit, RTT: rtt})
+		}
+
+		if limit <= 10 {
+			t.Errorf("Got limit %d, expected it to grow", limit)
+		}
+	})
+
+	t.Run("It shrinks while latency rises", func(t *testing.T) {
+		g := NewGradient2()
+		limit := 100
+
+		for i := 0; i < 100; i++ {
+			limit = g.Success(Sample{Limit: limit, MaxLimit: 1000, Inflight: limit, RTT: rtt})
+		}
+
+		grown := limit
+
+		for i := 0; i < 20; i++ {
+			limit = g.Success(Sample{Limit: limit, MaxLimit: 1000, Inflight: limit, RTT: 10 * rtt})
+		}
+
+		if limit >= grown {
+			t.Errorf("Got limit %d, expected it to shrink below %d", limit, grown)
+		}
+	})
+
+	t.Run("It doesn't grow an unused limit", func(t *testing.T) {
+		g := NewGradient2()
+
+		limit := g.Success(Sample{Limit: 10, MaxLimit: 1000, Inflight: 1, RTT: rtt})
+
+		if limit != 10 {
+			t.Errorf("Got limit %d, expected %d", limit, 10)
+		}
+	})
+
+	t.Run("It doesn't grow past what's in use", func(t *testing.T) {
+		g := NewGradient2()
+
+		limit := g.Success(Sample{Limit: 10, MaxLimit: 1000, Inflight: 10 - g.QueueSize - 1, RTT: rtt})
+
+		if limit != 10 {
+			t.Errorf("Got limit %d, expected %d", limit, 10)
+		}
+	})
+
+	t.Run("It shrinks an unused limit while latency rises", func(t *testing.T) {
+		g := NewGradient2()
+		limit := 100
+
+		for i := 0; i < 100; i++ {
+			limit = g.Success(Sample{Limit: limit, MaxLimit: 1000, Inflight: limit, RTT: rtt})
+		}
+
+		grown := limit
+
+		for i := 0; i < 20; i++ {
+			limit = g.Success(Sample{Limit: limit, MaxLimit: 1000, Inflight: 1, RTT: 10 * rtt})
+		}
+
+		if limit >= grown {
+			t.Errorf("Got limit %d, expected it to shrink below %d", limit, grown)
+		}
+	})
+
+	t.Run("It respects the max limit", func(t *testing.T) {
+		g := NewGradient2()
+		limit := 10
+
+		for i := 0; i < 100; i++ {
+			limit = g.Success(Sample{Limit: limit, MaxLimit: 20, Inflight: limit, RTT: rtt})
+		}
+
+		if limit != 20 {
+			t.Errorf("Got limit %d, expected %d", limit, 20)
+		}
+	})
+}
+
+func TestGradient2Backoff(t *testing.T) {
+	g := NewGradient2()
+
+	limit := g.Backoff(Sample{Limit: 100, MaxLimit: 1000})
+
+	if limit != 75 {
+		t.Errorf("Got limit %d, expected %d", limit, 75)
+	}
+}
@@ -0,0 +1,312 @@
+// Package grpccongestion provides gRPC interceptors that limit calls
+// with a congestion Limiter.
+//
+// Client interceptors treat ResourceExhausted and Unavailable as a
+// backoff signal, and retry with a congestion.Backoff. Server
+// interceptors shed load with ResourceExhausted when the Limiter drops
+// a call. Both take the priority from call metadata.
+package grpccongestion
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joshbohde/congestion"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultPriorityKey is the metadata key holding a call's priority
+const DefaultPriorityKey = "x-priority"
+
+// DefaultMaxRetries is the most times a client call is retried, unless
+// set with WithMaxRetries
+const DefaultMaxRetries = 3
+
+// Option configures an interceptor
+type Option func(*options)
+
+type options struct {
+	priorityKey string
+	step        time.Duration
+	maxRetries  int
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		priorityKey: DefaultPriorityKey,
+		step:        100 * time.Millisecond,
+		maxRetries:  DefaultMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithPriorityKey sets the metadata key holding a call's priority. Defaults to DefaultPriorityKey.
+func WithPriorityKey(key string) Option {
+	return func(o *options) {
+		o.priorityKey = key
+	}
+}
+
+// WithStep sets the initial delay between client retries. Defaults to 100ms.
+func WithStep(step time.Duration) Option {
+	return func(o *options) {
+		o.step = step
+	}
+}
+
+// WithMaxRetries sets the most times a client call is retried. Defaults
+// to DefaultMaxRetries. A negative n retries until the call's context
+// ends, but only if it has a deadline, since Unavailable is also what
+// a call gets when the server can't be reached at all.
+func WithMaxRetries(n int) Option {
+	return func(o *options) {
+		o.maxRetries = n
+	}
+}
+
+// parsePriority returns the first priority in the metadata, defaulting to 0.
+func parsePriority(md metadata.MD, key string) int {
+	for _, v := range md.Get(key) {
+		if priority, err := strconv.Atoi(v); err == nil {
+			return priority
+		}
+	}
+	return 0
+}
+
+// clientPriority is the priority from congestion.WithPriority, or else
+// the outgoing metadata. It is added to the outgoing metadata so
+// servers see it too.
+func (o options) clientPriority(ctx context.Context) (context.Context, int) {
+	if priority, ok := congestion.PriorityFromContext(ctx); ok {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if len(md.Get(o.priorityKey)) == 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, o.priorityKey, strconv.Itoa(priority))
+		}
+		return ctx, priority
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return ctx, parsePriority(md, o.priorityKey)
+}
+
+// retries returns the most times a call with the context is retried, or
+// -1 to retry until it ends.
+func (o options) retries(ctx context.Context) int {
+	if o.maxRetries >= 0 {
+		return o.maxRetries
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		return DefaultMaxRetries
+	}
+	return -1
+}
+
+func (o options) serverPriority(ctx context.Context) int {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return parsePriority(md, o.priorityKey)
+}
+
+// shouldBackoff returns if the error signals the upstream is overloaded
+func shouldBackoff(err error) bool {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// toStatus converts an error from the Limiter to a gRPC status error
+func toStatus(err error) error {
+	if errors.Is(err, congestion.Dropped) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, congestion.ErrDeadline) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.FromContextError(err).Err()
+}
+
+// UnaryClientInterceptor acquires from the Limiter for each call,
+// retrying calls that fail with ResourceExhausted or Unavailable.
+func UnaryClientInterceptor(l *congestion.Limiter, opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		ctx, priority := o.clientPriority(ctx)
+
+		b := congestion.Backoff{
+			Step:     o.step,
+			Limiter:  l,
+			Priority: priority,
+		}
+		defer b.Close()
+
+		retries := o.retries(ctx)
+
+		for attempt := 0; b.Try(ctx); attempt++ {
+			err := invoker(ctx, method, req, reply, cc, callOpts...)
+
+			if !shouldBackoff(err) {
+				return err
+			}
+
+			// We won't retry, but still need to signal the overload
+			if retries >= 0 && attempt >= retries {
+				l.Backoff()
+				return err
+			}
+		}
+
+		return toStatus(b.Error)
+	}
+}
+
+// StreamClientInterceptor acquires from the Limiter for each stream,
+// holding it until the stream ends. Creating the stream is retried if
+// it fails with ResourceExhausted or Unavailable, and the stream
+// failing with either signals a backoff.
+func StreamClientInterceptor(l *congestion.Limiter, opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, priority := o.clientPriority(ctx)
+
+		b := &congestion.Backoff{
+			Step:     o.step,
+			Limiter:  l,
+			Priority: priority,
+		}
+
+		retries := o.retries(ctx)
+
+		for attempt := 0; b.Try(ctx); attempt++ {
+			stream, err := streamer(ctx, desc, cc, method, callOpts...)
+
+			if err == nil {
+				return newClientStream(ctx, stream, desc, l, b), nil
+			}
+
+			if !shouldBackoff(err) {
+				b.Close()
+				return nil, err
+			}
+
+			if retries >= 0 && attempt >= retries {
+				l.Backoff()
+				b.Close()
+				return nil, err
+			}
+		}
+
+		b.Close()
+		return nil, toStatus(b.Error)
+	}
+}
+
+// clientStream releases the Limiter when the stream ends, either with
+// an error, with the response of a stream that only has one, or when
+// its context ends.
+type clientStream struct {
+	grpc.ClientStream
+	desc    *grpc.StreamDesc
+	limiter *congestion.Limiter
+	backoff *congestion.Backoff
+	once    sync.Once
+	done    chan struct{}
+}
+
+func newClientStream(ctx context.Context, stream grpc.ClientStream, desc *grpc.StreamDesc, l *congestion.Limiter, b *congestion.Backoff) *clientStream {
+	s := &clientStream{
+		ClientStream: stream,
+		desc:         desc,
+		limiter:      l,
+		backoff:      b,
+		done:         make(chan struct{}),
+	}
+
+	// Callers may give up on a stream without draining it
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.finish(ctx.Err())
+		case <-s.done:
+		}
+	}()
+
+	return s
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		if shouldBackoff(err) {
+			s.limiter.Backoff()
+		}
+		s.backoff.Close()
+		close(s.done)
+	})
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil && err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	// Without server streaming, the first response ends the stream
+	if err != nil || !s.desc.ServerStreams {
+		s.finish(err)
+	}
+	return err
+}
+
+// UnaryServerInterceptor acquires from the Limiter for each call,
+// failing with ResourceExhausted if it is dropped. The handler's
+// latency is reported to the Limiter's algorithm.
+func UnaryServerInterceptor(l *congestion.Limiter, opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		token, err := l.AcquireToken(ctx, o.serverPriority(ctx))
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		defer token.Success()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor acquires from the Limiter for each stream,
+// failing with ResourceExhausted if it is dropped.
+func StreamServerInterceptor(l *congestion.Limiter, opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+
+		token, err := l.AcquireToken(ctx, o.serverPriority(ctx))
+		if err != nil {
+			return toStatus(err)
+		}
+		defer token.Success()
+
+		return handler(srv, ss)
+	}
+}
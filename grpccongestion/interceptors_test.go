@@ -0,0 +1,392 @@
+package grpccongestion
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joshbohde/congestion"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// overloaded fails the first calls with a code, then succeeds. It
+// blocks calls until released, if release is set.
+type overloaded struct {
+	healthpb.UnimplementedHealthServer
+
+	code     codes.Code
+	failures int64
+	calls    int64
+	priority int64
+	release  chan struct{}
+}
+
+func (s *overloaded) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	atomic.StoreInt64(&s.priority, int64(parsePriority(md, DefaultPriorityKey)))
+
+	if atomic.AddInt64(&s.calls, 1) <= s.failures {
+		return nil, status.Error(s.code, "overloaded")
+	}
+
+	if s.release != nil {
+		<-s.release
+	}
+
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *overloaded) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if atomic.AddInt64(&s.calls, 1) <= s.failures {
+		return status.Error(s.code, "overloaded")
+	}
+
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+// dial starts a server over bufconn, returning a client connected to it
+func dial(t *testing.T, srv healthpb.HealthServer, serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) healthpb.HealthClient {
+	conn := dialServer(t, func(s *grpc.Server) {
+		healthpb.RegisterHealthServer(s, srv)
+	}, serverOpts, dialOpts...)
+
+	return healthpb.NewHealthClient(conn)
+}
+
+// dialServer starts a server over bufconn with the services registered,
+// returning a connection to it
+func dialServer(t *testing.T, register func(s *grpc.Server), serverOpts []grpc.ServerOption, dialOpts ...grpc.DialOption) *grpc.ClientConn {
+	lis := bufconn.Listen(1024 * 1024)
+
+	s := grpc.NewServer(serverOpts...)
+	register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	dialOpts = append(dialOpts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// uploadDesc is a client streaming method, which the health service lacks
+var uploadDesc = grpc.StreamDesc{
+	StreamName:    "Upload",
+	ClientStreams: true,
+}
+
+// upload receives requests until the client closes, then responds once
+func upload(srv interface{}, stream grpc.ServerStream) error {
+	for {
+		err := stream.RecvMsg(&healthpb.HealthCheckRequest{})
+		if err == io.EOF {
+			return stream.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func registerUpload(s *grpc.Server) {
+	desc := uploadDesc
+	desc.Handler = upload
+
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.Upload",
+		HandlerType: (*interface{})(nil),
+		Streams:     []grpc.StreamDesc{desc},
+	}, struct{}{})
+}
+
+// waitForOutstanding blocks until the limiter has n outstanding, or fails after a second
+func waitForOutstanding(t *testing.T, l *congestion.Limiter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Outstanding != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d outstanding, expected %d", l.Stats().Outstanding, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	for _, code := range []codes.Code{codes.ResourceExhausted, codes.Unavailable} {
+		srv := &overloaded{code: code, failures: 2}
+		l := congestion.New(congestion.Config{Capacity: 10, MaxLimit: 10})
+
+		client := dial(t, srv, nil, grpc.WithUnaryInterceptor(UnaryClientInterceptor(&l, WithStep(time.Millisecond))))
+
+		ctx := congestion.WithPriority(context.Background(), 7)
+
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal("Got an error:", err)
+		}
+
+		if srv.calls != 3 {
+			t.Errorf("Got %d calls, expected %d", srv.calls, 3)
+		}
+
+		if srv.priority != 7 {
+			t.Errorf("Server got priority %d, expected %d", srv.priority, 7)
+		}
+
+		s := l.Stats()
+		if s.Backoffs != 2 || s.Outstanding != 0 {
+			t.Errorf("Got %d backoffs and %d outstanding, expected %d and %d", s.Backoffs, s.Outstanding, 2, 0)
+		}
+	}
+}
+
+func TestUnaryClientInterceptorMaxRetries(t *testing.T) {
+	srv := &overloaded{code: codes.ResourceExhausted, failures: 10}
+	l := congestion.New(congestion.Config{Capacity: 10, MaxLimit: 10})
+
+	client := dial(t, srv, nil, grpc.WithUnaryInterceptor(UnaryClientInterceptor(&l, WithStep(time.Millisecond), WithMaxRetries(1))))
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Got %v, expected %v", err, codes.ResourceExhausted)
+	}
+
+	if srv.calls != 2 {
+		t.Errorf("Got %d calls, expected %d", srv.calls, 2)
+	}
+
+	if s := l.Stats(); s.Outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 0)
+	}
+}
+
+func TestUnaryClientInterceptorDefaultRetries(t *testing.T) {
+	cases := []struct {
+		Name       string
+		MaxRetries []Option
+		Timeout    time.Duration
+		Calls      int64
+	}{
+		{"Default", nil, 0, DefaultMaxRetries + 1},
+		// Without a deadline, this would retry forever
+		{"UnlimitedWithoutDeadline", []Option{WithMaxRetries(-1)}, 0, DefaultMaxRetries + 1},
+		{"UnlimitedWithDeadline", []Option{WithMaxRetries(-1)}, time.Second, 20},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			srv := &overloaded{code: codes.Unavailable, failures: 19}
+			l := congestion.New(congestion.Config{Capacity: 10, MaxLimit: 10})
+
+			opts := append([]Option{WithStep(time.Microsecond)}, tc.MaxRetries...)
+			client := dial(t, srv, nil, grpc.WithUnaryInterceptor(UnaryClientInterceptor(&l, opts...)))
+
+			ctx := context.Background()
+			if tc.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.Timeout)
+				defer cancel()
+			}
+
+			client.Check(ctx, &healthpb.HealthCheckRequest{})
+
+			if srv.calls != tc.Calls {
+				t.Errorf("Got %d calls, expected %d", srv.calls, tc.Calls)
+			}
+
+			if s := l.Stats(); s.Outstanding != 0 {
+				t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 0)
+			}
+		})
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	srv := &overloaded{code: codes.Unavailable, failures: 1}
+	l := congestion.New(congestion.Config{Capacity: 10, MaxLimit: 10})
+
+	client := dial(t, srv, nil, grpc.WithStreamInterceptor(StreamClientInterceptor(&l, WithStep(time.Millisecond))))
+
+	// The failure arrives on the stream, so it is a backoff signal but not retried
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if n := l.Stats().Outstanding; n != 1 {
+		t.Errorf("Got %d outstanding while streaming, expected %d", n, 1)
+	}
+
+	_, err = stream.Recv()
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Got %v, expected %v", err, codes.Unavailable)
+	}
+
+	s := l.Stats()
+	if s.Backoffs != 1 || s.Outstanding != 0 {
+		t.Errorf("Got %d backoffs and %d outstanding, expected %d and %d", s.Backoffs, s.Outstanding, 1, 0)
+	}
+
+	stream, err = client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	for err == nil {
+		_, err = stream.Recv()
+	}
+
+	if err != io.EOF {
+		t.Errorf("Got %v, expected %v", err, io.EOF)
+	}
+
+	if n := l.Stats().Outstanding; n != 0 {
+		t.Errorf("Got %d outstanding after the stream ended, expected %d", n, 0)
+	}
+}
+
+func TestStreamClientInterceptorClientStreaming(t *testing.T) {
+	l := congestion.New(congestion.Config{Capacity: 10, MaxLimit: 10})
+
+	conn := dialServer(t, registerUpload, nil, grpc.WithStreamInterceptor(StreamClientInterceptor(&l, WithStep(time.Millisecond))))
+
+	stream, err := conn.NewStream(context.Background(), &uploadDesc, "/test.Upload/Upload")
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = stream.SendMsg(&healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal("Got an error:", err)
+		}
+	}
+
+	err = stream.CloseSend()
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	// The response succeeds without an error, but still ends the stream
+	err = stream.RecvMsg(&healthpb.HealthCheckResponse{})
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if n := l.Stats().Outstanding; n != 0 {
+		t.Errorf("Got %d outstanding after the response, expected %d", n, 0)
+	}
+}
+
+func TestStreamClientInterceptorCanceled(t *testing.T) {
+	srv := &overloaded{}
+	l := congestion.New(congestion.Config{Capacity: 10, MaxLimit: 10})
+
+	client := dial(t, srv, nil, grpc.WithStreamInterceptor(StreamClientInterceptor(&l, WithStep(time.Millisecond))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	_, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	waitForOutstanding(t, &l, 1)
+
+	// The stream is never read, so only its context ending releases it
+	cancel()
+
+	waitForOutstanding(t, &l, 0)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	srv := &overloaded{release: make(chan struct{})}
+	l := congestion.New(congestion.Config{Capacity: 0, MaxLimit: 1})
+
+	client := dial(t, srv, []grpc.ServerOption{grpc.UnaryInterceptor(UnaryServerInterceptor(&l))})
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		done <- err
+	}()
+
+	// Wait for the first call to hold the limiter
+	for l.Stats().Outstanding == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// There is no room to queue, so this is shed
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Got %v, expected %v", err, codes.ResourceExhausted)
+	}
+
+	close(srv.release)
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	if s := l.Stats(); s.Drops != 1 || s.Outstanding != 0 {
+		t.Errorf("Got %d drops and %d outstanding, expected %d and %d", s.Drops, s.Outstanding, 1, 0)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	srv := &overloaded{}
+	l := congestion.New(congestion.Config{Capacity: 0, MaxLimit: 1})
+
+	client := dial(t, srv, []grpc.ServerOption{grpc.StreamInterceptor(StreamServerInterceptor(&l))})
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	for err == nil {
+		_, err = stream.Recv()
+	}
+
+	if err != io.EOF {
+		t.Errorf("Got %v, expected %v", err, io.EOF)
+	}
+
+	if s := l.Stats(); s.Acquisitions != 1 {
+		t.Errorf("Got %d acquisitions, expected %d", s.Acquisitions, 1)
+	}
+}
+
+func TestPriorityFromMetadata(t *testing.T) {
+	o := newOptions(nil)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), DefaultPriorityKey, "3")
+	if _, priority := o.clientPriority(ctx); priority != 3 {
+		t.Errorf("Got priority %d, expected %d", priority, 3)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultPriorityKey, "junk"))
+	if priority := o.serverPriority(ctx); priority != 0 {
+		t.Errorf("Got priority %d, expected %d", priority, 0)
+	}
+}
@@ -0,0 +1,110 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Handler is http.Handler middleware that sheds load with a Limiter.
+// Requests dropped from the queue get a 429, and requests that wait in
+// the queue for longer than MaxWait get a 503, both with a Retry-After
+// computed from the handler's latency and the queue depth.
+//
+// Nothing signals a backoff on the server side, so the Limiter should
+// use a latency based algorithm, as NewHandler does by default.
+type Handler struct {
+	// Handler serves the requests that are admitted
+	Handler http.Handler
+	// Limiter limits the requests
+	Limiter *Limiter
+	// PriorityHeader names a request header holding the priority. Defaults to priority 0.
+	PriorityHeader string
+	// MaxWait is the longest a request waits in the queue. Zero waits
+	// until the request's context ends.
+	MaxWait time.Duration
+
+	mu      sync.Mutex
+	latency ewma
+}
+
+// NewHandler wraps the handler with a Limiter built from the Config.
+// The Config's Algorithm defaults to Gradient2, so the limit adapts to
+// the handler's latency.
+func NewHandler(handler http.Handler, cfg Config) *Handler {
+	if cfg.Algorithm == nil {
+		cfg.Algorithm = func() LimitAlgorithm { return NewGradient2() }
+	}
+
+	l := New(cfg)
+
+	return &Handler{
+		Handler: handler,
+		Limiter: &l,
+	}
+}
+
+// latencyWindow is the number of requests averaged for Retry-After
+const latencyWindow = 100
+
+// retryAfter estimates how long until the queue has drained, in whole seconds.
+func (h *Handler) retryAfter() string {
+	s := h.Limiter.Stats()
+
+	h.mu.Lock()
+	latency := h.latency.value
+	h.mu.Unlock()
+
+	seconds := math.Ceil(latency * float64(s.Queued+1) / float64(s.Limit) / float64(time.Second))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return strconv.Itoa(int(seconds))
+}
+
+// shed rejects the request with the status
+func (h *Handler) shed(w http.ResponseWriter, status int) {
+	w.Header().Set("Retry-After", h.retryAfter())
+	http.Error(w, http.StatusText(status), status)
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	priority, _ := headerPriority(r.Header, h.PriorityHeader)
+
+	ctx := r.Context()
+	if h.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.MaxWait)
+		defer cancel()
+	}
+
+	token, err := h.Limiter.AcquireToken(ctx, priority)
+
+	switch {
+	case errors.Is(err, Dropped):
+		h.shed(w, http.StatusTooManyRequests)
+		return
+	case err != nil:
+		h.shed(w, http.StatusServiceUnavailable)
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		latency := time.Since(start)
+
+		h.mu.Lock()
+		h.latency.add(latencyWindow, float64(latency))
+		h.mu.Unlock()
+
+		token.Success()
+	}()
+
+	h.Handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,126 @@
+package congestion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingHandler blocks until released, signaling when it has started
+func blockingHandler() (http.Handler, chan struct{}, chan struct{}) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}), started, release
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}), Config{Capacity: 10, MaxLimit: 10})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("Got %d %q, expected %d %q", w.Code, w.Body.String(), http.StatusOK, "ok")
+	}
+
+	if _, ok := h.Limiter.algorithm.(*Gradient2); !ok {
+		t.Errorf("Got algorithm %T, expected a latency based default", h.Limiter.algorithm)
+	}
+
+	if s := h.Limiter.Stats(); s.Outstanding != 0 || s.Acquisitions != 1 {
+		t.Errorf("Got %+v, expected a single released acquisition", s)
+	}
+}
+
+func TestHandlerSheds(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Capacity int
+		Expected int
+	}{
+		{"Dropped", 0, http.StatusTooManyRequests},
+		{"Timeout", 1, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			blocking, started, release := blockingHandler()
+
+			h := NewHandler(blocking, Config{Capacity: tc.Capacity, MaxLimit: 1})
+			h.MaxWait = 10 * time.Millisecond
+
+			done := make(chan struct{})
+			go func() {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+				close(done)
+			}()
+			<-started
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tc.Expected {
+				t.Errorf("Got %d, expected %d", w.Code, tc.Expected)
+			}
+
+			if w.Header().Get("Retry-After") != "1" {
+				t.Errorf("Got Retry-After %q, expected %q", w.Header().Get("Retry-After"), "1")
+			}
+
+			close(release)
+			<-done
+		})
+	}
+}
+
+func TestHandlerPriority(t *testing.T) {
+	blocking, started, release := blockingHandler()
+
+	h := NewHandler(blocking, Config{Capacity: 1, MaxLimit: 1})
+	h.PriorityHeader = "X-Priority"
+
+	serve := func(priority string) chan int {
+		code := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Priority", priority)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			code <- w.Code
+		}()
+		return code
+	}
+
+	first := serve("0")
+	<-started
+
+	low := serve("1")
+	waitForQueued(h.Limiter, 1)
+
+	// The higher priority request evicts the lower one from the full queue
+	high := serve("2")
+
+	if code := <-low; code != http.StatusTooManyRequests {
+		t.Errorf("Got %d for the low priority request, expected %d", code, http.StatusTooManyRequests)
+	}
+
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("Got %d, expected %d", code, http.StatusOK)
+	}
+	if code := <-high; code != http.StatusOK {
+		t.Errorf("Got %d for the high priority request, expected %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,41 @@
+package congestion
+
+import "time"
+
+// Parent returns the Limiter's parent, or nil if it has none.
+func (l *Limiter) Parent() *Limiter {
+	return l.parent
+}
+
+// abandon n slots acquired from this Limiter without updating the
+// limit, because acquiring its parent failed.
+func (l *Limiter) abandon(n int) {
+	l.mu.Lock()
+	l.rtt(n)
+	l.release(n)
+	l.unlock()
+}
+
+// releaseWith releases a token held for a child, letting update change
+// the limit, and then does the same for any parent.
+func (l *Limiter) releaseWith(rtt time.Duration, update func(l *Limiter, s Sample)) {
+	l.mu.Lock()
+
+	// Keep the anonymous release timings balanced
+	l.rtt(1)
+
+	s := l.sample()
+	s.RTT = rtt
+
+	if update != nil {
+		update(l, s)
+	}
+	l.served(rtt)
+
+	l.release(1)
+	l.unlock()
+
+	if l.parent != nil {
+		l.parent.releaseWith(rtt, update)
+	}
+}
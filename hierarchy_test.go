@@ -0,0 +1,203 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParentIsAcquired(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	a := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+	b := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	ctx := context.Background()
+
+	err := a.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if parent.outstanding != 1 {
+		t.Errorf("Got %d outstanding in the parent, expected %d", parent.outstanding, 1)
+	}
+
+	// The parent is full, so a sibling waits on it
+	done := make(chan error)
+	go func() {
+		done <- b.Acquire(ctx, 0)
+	}()
+
+	waitForQueued(&parent, 1)
+
+	a.Release()
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	b.Release()
+
+	if a.outstanding != 0 || b.outstanding != 0 || parent.outstanding != 0 {
+		t.Errorf("Got %d, %d and %d outstanding, expected none", a.outstanding, b.outstanding, parent.outstanding)
+	}
+}
+
+func TestParentCancelDoesNotLeak(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	err := parent.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- child.Acquire(ctx, 0)
+	}()
+
+	// The child's slot is held while waiting on the parent
+	waitForQueued(&parent, 1)
+	cancel()
+
+	err = <-done
+	if err != context.Canceled {
+		t.Errorf("Got %v, expected %v", err, context.Canceled)
+	}
+
+	if child.outstanding != 0 || len(child.started) != 0 {
+		t.Errorf("Got %d outstanding in the child, expected %d", child.outstanding, 0)
+	}
+
+	if child.limit != 1 {
+		t.Errorf("Got child limit %d, expected %d", child.limit, 1)
+	}
+
+	parent.Release()
+
+	if parent.outstanding != 0 || parent.waiters.Len() != 0 {
+		t.Errorf("Got %d outstanding and %d queued in the parent, expected none", parent.outstanding, parent.waiters.Len())
+	}
+}
+
+func TestParentDropDoesNotLeak(t *testing.T) {
+	parent := New(Config{Capacity: 0, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	ctx := context.Background()
+
+	err := parent.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	err = child.Acquire(ctx, 0)
+	if !errors.Is(err, Dropped) {
+		t.Errorf("Got %v, expected %v", err, Dropped)
+	}
+
+	if child.outstanding != 0 {
+		t.Errorf("Got %d outstanding in the child, expected %d", child.outstanding, 0)
+	}
+
+	parent.Release()
+}
+
+func TestParentPriority(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	low := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+	high := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	ctx := context.Background()
+
+	err := parent.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	order := make(chan int, 2)
+	acquire := func(l *Limiter, priority int) {
+		err := l.Acquire(ctx, priority)
+		if err != nil {
+			t.Error("Got an error:", err)
+			return
+		}
+		order <- priority
+		l.Release()
+	}
+
+	go acquire(&low, 1)
+	waitForQueued(&parent, 1)
+
+	go acquire(&high, 2)
+	waitForQueued(&parent, 2)
+
+	parent.Release()
+
+	for _, expected := range []int{2, 1} {
+		select {
+		case priority := <-order:
+			if priority != expected {
+				t.Errorf("Got priority %d, expected %d", priority, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting to acquire")
+		}
+	}
+}
+
+func TestTokenBackoffAttribution(t *testing.T) {
+	algorithm := func() LimitAlgorithm {
+		return fixedAlgorithm{success: 6, backoff: 2}
+	}
+
+	cases := []struct {
+		Name     string
+		Release  func(t *Token) error
+		Child    int
+		Parent   int
+		Backoffs uint64
+	}{
+		{"Success", (*Token).Success, 6, 6, 0},
+		{"Backoff", (*Token).Backoff, 2, 4, 0},
+		{"BackoffParent", (*Token).BackoffParent, 4, 2, 1},
+		{"Dropped", (*Token).Dropped, 2, 4, 0},
+		{"Ignore", (*Token).Ignore, 4, 4, 0},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			parent := New(Config{Capacity: 10, MaxLimit: 10, InitialLimit: 4, Algorithm: algorithm})
+			child := New(Config{Capacity: 10, MaxLimit: 10, InitialLimit: 4, Algorithm: algorithm, Parent: &parent})
+
+			token, err := child.AcquireToken(context.Background(), 0)
+			if err != nil {
+				t.Fatal("Got an error:", err)
+			}
+
+			err = tc.Release(token)
+			if err != nil {
+				t.Error("Got an error:", err)
+			}
+
+			if child.limit != tc.Child || parent.limit != tc.Parent {
+				t.Errorf("Got limits %d and %d, expected %d and %d", child.limit, parent.limit, tc.Child, tc.Parent)
+			}
+
+			if s := parent.Stats(); s.Backoffs != tc.Backoffs || s.Outstanding != 0 {
+				t.Errorf("Got %d backoffs and %d outstanding in the parent, expected %d and %d", s.Backoffs, s.Outstanding, tc.Backoffs, 0)
+			}
+
+			err = tc.Release(token)
+			if err != ErrReleased {
+				t.Errorf("Got %v releasing twice, expected %v", err, ErrReleased)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package congestion
+
+import "time"
+
+// adaptOrder serves the newest waiters first once the waiter being
+// admitted was queued longer than the threshold, and the oldest first
+// again once the queue has drained. Newest first admits waiters that
+// were barely queued, so the delay of those admitted can't tell when
+// the overload is over.
+func (l *Limiter) adaptOrder(sojourn time.Duration) {
+	switch {
+	case l.waiters.Empty():
+		l.waiters.SetLIFO(false)
+	case sojourn > l.lifoThreshold:
+		l.waiters.SetLIFO(true)
+	}
+}
@@ -0,0 +1,46 @@
+package congestion
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLIFO(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1, LIFOThreshold: 5 * time.Millisecond})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			err := c.Acquire(context.Background(), 0)
+			if err != nil {
+				t.Error("Got an error:", err)
+			}
+			order <- i
+		}(i)
+		waitForQueued(&c, i+1)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// The oldest has waited too long, so the newest are served next
+	for _, expected := range []int{0, 2, 1} {
+		c.Release()
+
+		if i := <-order; i != expected {
+			t.Errorf("Got %d, expected %d", i, expected)
+		}
+	}
+
+	// Once the queue drains it is back to oldest first
+	if c.waiters.LIFO() {
+		t.Error("Expected to serve the oldest first")
+	}
+
+	c.Release()
+}
@@ -0,0 +1,128 @@
+package congestion
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// AcquireFast is an acquisition that didn't wait in the queue
+	AcquireFast = AcquireOutcome(iota)
+	// AcquireQueued is an acquisition that waited in the queue
+	AcquireQueued
+	// AcquireDropped is a waiter that was rejected or evicted from the queue
+	AcquireDropped
+	// AcquireCanceled is a waiter whose context ended while queued
+	AcquireCanceled
+)
+
+// AcquireOutcome is how a call to Acquire ended
+type AcquireOutcome int
+
+func (o AcquireOutcome) String() string {
+	switch o {
+	case AcquireFast:
+		return "fast"
+	case AcquireQueued:
+		return "queued"
+	case AcquireDropped:
+		return "dropped"
+	case AcquireCanceled:
+		return "canceled"
+	}
+	return fmt.Sprintf("outcome(%d)", o)
+}
+
+// AcquireEvent describes a call to Acquire
+type AcquireEvent struct {
+	Priority int
+	Outcome  AcquireOutcome
+	// Start is when Acquire was called
+	Start time.Time
+	// Wait is how long Acquire blocked
+	Wait time.Duration
+	// Queued is whether the waiter was pushed onto the queue. Waiters
+	// that are rejected before being queued, like an unreachable
+	// deadline, are not.
+	Queued bool
+	// Err is the error Acquire returned
+	Err error
+}
+
+// RetryEvent describes a retry scheduled by Backoff.Try
+type RetryEvent struct {
+	// Retry is the number of this retry, starting at 1
+	Retry int
+	// Priority is the priority the retry is queued at
+	Priority int
+	// Sleep is the delay before the retry
+	Sleep time.Duration
+}
+
+// BackoffEvent describes a Backoff that has been closed
+type BackoffEvent struct {
+	// Retries is the number of retries made
+	Retries int
+	// Err is the error that stopped the Backoff, or nil if it succeeded
+	Err error
+}
+
+// Observer is notified of events in a Limiter, and any Backoff using
+// it. It is called outside of the Limiter's lock, but must be safe for
+// concurrent use, and should not block.
+type Observer interface {
+	// Acquired is called when Acquire returns
+	Acquired(ctx context.Context, e AcquireEvent)
+	// Retried is called when a Backoff schedules a retry
+	Retried(ctx context.Context, e RetryEvent)
+	// Closed is called when a Backoff is closed
+	Closed(e BackoffEvent)
+}
+
+// observe notifies the observer of an Acquire, if there is one.
+func (l *Limiter) observe(ctx context.Context, priority int, start time.Time, outcome AcquireOutcome, queued bool, err error) {
+	if l.observer == nil {
+		return
+	}
+
+	e := AcquireEvent{
+		Priority: priority,
+		Outcome:  outcome,
+		Start:    start,
+		Queued:   queued,
+		Err:      err,
+	}
+	if outcome != AcquireFast {
+		e.Wait = time.Since(start)
+	}
+
+	l.observer.Acquired(ctx, e)
+}
+
+// observers notifies each of its Observers in order
+type observers []Observer
+
+// Observers combines multiple Observers into one, e.g. to export both
+// metrics and traces from a Limiter.
+func Observers(o ...Observer) Observer {
+	return observers(o)
+}
+
+func (obs observers) Acquired(ctx context.Context, e AcquireEvent) {
+	for _, o := range obs {
+		o.Acquired(ctx, e)
+	}
+}
+
+func (obs observers) Retried(ctx context.Context, e RetryEvent) {
+	for _, o := range obs {
+		o.Retried(ctx, e)
+	}
+}
+
+func (obs observers) Closed(e BackoffEvent) {
+	for _, o := range obs {
+		o.Closed(e)
+	}
+}
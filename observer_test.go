@@ -0,0 +1,180 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver keeps the events it was given
+type recordingObserver struct {
+	mu       sync.Mutex
+	acquired []AcquireEvent
+	retried  []RetryEvent
+	closed   []BackoffEvent
+}
+
+func (r *recordingObserver) Acquired(ctx context.Context, e AcquireEvent) {
+	r.mu.Lock()
+	r.acquired = append(r.acquired, e)
+	r.mu.Unlock()
+}
+
+func (r *recordingObserver) Retried(ctx context.Context, e RetryEvent) {
+	r.mu.Lock()
+	r.retried = append(r.retried, e)
+	r.mu.Unlock()
+}
+
+func (r *recordingObserver) Closed(e BackoffEvent) {
+	r.mu.Lock()
+	r.closed = append(r.closed, e)
+	r.mu.Unlock()
+}
+
+func (r *recordingObserver) outcomes() []AcquireOutcome {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var outcomes []AcquireOutcome
+	for _, e := range r.acquired {
+		outcomes = append(outcomes, e.Outcome)
+	}
+	return outcomes
+}
+
+func TestObserverAcquire(t *testing.T) {
+	o := &recordingObserver{}
+	c := New(Config{Capacity: 1, MaxLimit: 1, Observer: o})
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	// Queue a waiter, which will be let in
+	queued := make(chan error)
+	go func() {
+		queued <- c.Acquire(ctx, 0)
+	}()
+	waitForQueued(&c, 1)
+
+	// The queue is full, so this is dropped
+	err = c.Acquire(ctx, 0)
+	if !errors.Is(err, Dropped) {
+		t.Errorf("Got %v, expected %v", err, Dropped)
+	}
+
+	c.Release()
+	<-queued
+
+	// Queue a waiter, then cancel it
+	canceled, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(canceled, 0)
+	}()
+	waitForQueued(&c, 1)
+	cancel()
+	<-done
+
+	c.Release()
+
+	expected := []AcquireOutcome{AcquireFast, AcquireDropped, AcquireQueued, AcquireCanceled}
+	actual := o.outcomes()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Got %v, expected %v", actual, expected)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Got %v, expected %v", actual, expected)
+		}
+	}
+
+	// Only the waiters that made it into the queue were queued
+	for i, queued := range []bool{false, false, true, true} {
+		if o.acquired[i].Queued != queued {
+			t.Errorf("Got queued %t for %v, expected %t", o.acquired[i].Queued, o.acquired[i].Outcome, queued)
+		}
+	}
+}
+
+func TestObserverDeadline(t *testing.T) {
+	o := &recordingObserver{}
+	c := New(Config{Capacity: 10, MaxLimit: 1, EarliestDeadlineFirst: true, Observer: o})
+	c.service.add(serviceWindow, float64(time.Second))
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = c.Acquire(ctx, 0)
+	if err != ErrDeadline {
+		t.Errorf("Got %v, expected %v", err, ErrDeadline)
+	}
+
+	if e := o.acquired[1]; e.Outcome != AcquireDropped || e.Queued {
+		t.Errorf("Got %v and queued %t, expected %v and %t", e.Outcome, e.Queued, AcquireDropped, false)
+	}
+
+	c.Release()
+}
+
+func TestObserverBackoff(t *testing.T) {
+	o := &recordingObserver{}
+	c := New(Config{Capacity: 10, MaxLimit: 10, Observer: o})
+
+	b := Backoff{
+		Limiter:  &c,
+		Step:     time.Millisecond,
+		Priority: 5,
+	}
+
+	tries := 0
+	for b.Try(context.Background()) {
+		tries++
+		if tries == 3 {
+			break
+		}
+	}
+	b.Close()
+	b.Close()
+
+	if len(o.retried) != 2 {
+		t.Fatalf("Got %d retries, expected %d", len(o.retried), 2)
+	}
+
+	for i, e := range o.retried {
+		if e.Retry != i+1 || e.Priority != 6+i {
+			t.Errorf("Got %+v, expected retry %d at priority %d", e, i+1, 6+i)
+		}
+	}
+
+	if len(o.closed) != 1 || o.closed[0].Retries != 2 || o.closed[0].Err != nil {
+		t.Errorf("Got %+v, expected a single close with 2 retries", o.closed)
+	}
+}
+
+func TestObservers(t *testing.T) {
+	a := &recordingObserver{}
+	b := &recordingObserver{}
+	c := New(Config{Capacity: 10, MaxLimit: 10, Observer: Observers(a, b)})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	c.Release()
+
+	if len(a.acquired) != 1 || len(b.acquired) != 1 {
+		t.Errorf("Got %d and %d events, expected 1 each", len(a.acquired), len(b.acquired))
+	}
+}
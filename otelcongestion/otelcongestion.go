@@ -0,0 +1,207 @@
+// Package otelcongestion exports the state of congestion Limiters, and
+// the Backoffs using them, through OpenTelemetry traces and metrics.
+package otelcongestion
+
+import (
+	"context"
+	"sync"
+
+	"github.com/joshbohde/congestion"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const scope = "github.com/joshbohde/congestion/otelcongestion"
+
+// Option configures an Instrumentation
+type Option func(*options)
+
+type options struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// WithTracerProvider sets the TracerProvider. Defaults to the global provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider sets the MeterProvider. Defaults to the global provider.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *options) {
+		o.meterProvider = mp
+	}
+}
+
+// Instrumentation records spans for each Acquire, with span events for
+// each Backoff retry, and exports gauges for one or more named Limiters.
+type Instrumentation struct {
+	mu       sync.Mutex
+	limiters map[string]*congestion.Limiter
+
+	tracer       trace.Tracer
+	wait         metric.Float64Histogram
+	registration metric.Registration
+}
+
+// NewInstrumentation creates an Instrumentation, registering its metrics with the MeterProvider.
+func NewInstrumentation(opts ...Option) (*Instrumentation, error) {
+	o := options{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	meter := o.meterProvider.Meter(scope)
+
+	i := &Instrumentation{
+		limiters: map[string]*congestion.Limiter{},
+		tracer:   o.tracerProvider.Tracer(scope),
+	}
+
+	var err error
+
+	i.wait, err = meter.Float64Histogram("congestion.limiter.wait",
+		metric.WithDescription("Time spent waiting in the queue."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := meter.Int64ObservableGauge("congestion.limiter.limit",
+		metric.WithDescription("Current concurrency limit."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	outstanding, err := meter.Int64ObservableGauge("congestion.limiter.outstanding",
+		metric.WithDescription("Acquired tokens that haven't been released."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	queued, err := meter.Int64ObservableGauge("congestion.limiter.queued",
+		metric.WithDescription("Waiters in the queue."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	drops, err := meter.Int64ObservableCounter("congestion.limiter.drops",
+		metric.WithDescription("Waiters never admitted, because the queue was full, they were shed, or they would miss their deadline."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	backoffs, err := meter.Int64ObservableCounter("congestion.limiter.backoffs",
+		metric.WithDescription("Backoff signals."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	i.registration, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		for name, l := range i.limiters {
+			s := l.Stats()
+			attrs := metric.WithAttributes(attribute.String("limiter", name))
+
+			o.ObserveInt64(limit, int64(s.Limit), attrs)
+			o.ObserveInt64(outstanding, int64(s.Outstanding), attrs)
+			o.ObserveInt64(queued, int64(s.Queued), attrs)
+			o.ObserveInt64(drops, int64(s.Drops), attrs)
+			o.ObserveInt64(backoffs, int64(s.Backoffs), attrs)
+		}
+
+		return nil
+	}, limit, outstanding, queued, drops, backoffs)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Add a Limiter to be exported under the name. The Limiter should be
+// created with Observer(name) as its Config.Observer.
+func (i *Instrumentation) Add(name string, l *congestion.Limiter) {
+	i.mu.Lock()
+	i.limiters[name] = l
+	i.mu.Unlock()
+}
+
+// Remove the Limiter exported under the name.
+func (i *Instrumentation) Remove(name string) {
+	i.mu.Lock()
+	delete(i.limiters, name)
+	i.mu.Unlock()
+}
+
+// Close unregisters the Instrumentation's metrics.
+func (i *Instrumentation) Close() error {
+	return i.registration.Unregister()
+}
+
+// Observer returns a congestion.Observer tracing the Limiter named name.
+func (i *Instrumentation) Observer(name string) congestion.Observer {
+	return observer{
+		instrumentation: i,
+		name:            name,
+	}
+}
+
+// observer records events for a single named Limiter
+type observer struct {
+	instrumentation *Instrumentation
+	name            string
+}
+
+func (o observer) Acquired(ctx context.Context, e congestion.AcquireEvent) {
+	limiter := attribute.String("limiter", o.name)
+	outcome := attribute.String("outcome", e.Outcome.String())
+
+	_, span := o.instrumentation.tracer.Start(ctx, "congestion.Acquire",
+		trace.WithTimestamp(e.Start),
+		trace.WithAttributes(
+			limiter,
+			outcome,
+			attribute.Int("priority", e.Priority),
+			attribute.Bool("fast_path", e.Outcome == congestion.AcquireFast),
+			attribute.Bool("queued", e.Queued),
+			attribute.Bool("dropped", e.Outcome == congestion.AcquireDropped),
+		),
+	)
+
+	if e.Err != nil {
+		span.RecordError(e.Err)
+		span.SetStatus(codes.Error, e.Err.Error())
+	}
+
+	span.End(trace.WithTimestamp(e.Start.Add(e.Wait)))
+
+	o.instrumentation.wait.Record(ctx, e.Wait.Seconds(), metric.WithAttributes(limiter, outcome))
+}
+
+func (o observer) Retried(ctx context.Context, e congestion.RetryEvent) {
+	trace.SpanFromContext(ctx).AddEvent("congestion.Backoff.retry", trace.WithAttributes(
+		attribute.String("limiter", o.name),
+		attribute.Int("retry", e.Retry),
+		attribute.Int("priority", e.Priority),
+		attribute.Int64("sleep_ms", e.Sleep.Milliseconds()),
+	))
+}
+
+func (o observer) Closed(e congestion.BackoffEvent) {}
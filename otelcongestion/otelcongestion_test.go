@@ -0,0 +1,230 @@
+package otelcongestion
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joshbohde/congestion"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestInstrumentation(t *testing.T) (*Instrumentation, *tracetest.SpanRecorder, *sdkmetric.ManualReader, *sdktrace.TracerProvider) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	i, err := NewInstrumentation(WithTracerProvider(tp), WithMeterProvider(mp))
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	return i, spans, reader, tp
+}
+
+func attr(attrs []attribute.KeyValue, key string) attribute.Value {
+	for _, a := range attrs {
+		if string(a.Key) == key {
+			return a.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestAcquireSpans(t *testing.T) {
+	i, spans, _, _ := newTestInstrumentation(t)
+
+	l := congestion.New(congestion.Config{
+		Capacity: 1,
+		MaxLimit: 1,
+		Observer: i.Observer("upstream"),
+	})
+
+	ctx := context.Background()
+
+	err := l.Acquire(ctx, 5)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	queued := make(chan error)
+	go func() {
+		queued <- l.Acquire(ctx, 5)
+	}()
+
+	for l.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// There is no room to queue, so this is dropped
+	err = l.Acquire(ctx, 5)
+	if !errors.Is(err, congestion.Dropped) {
+		t.Errorf("Got %v, expected %v", err, congestion.Dropped)
+	}
+
+	l.Release()
+
+	err = <-queued
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	l.Release()
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("Got %d spans, expected %d", len(ended), 3)
+	}
+
+	cases := []struct {
+		Outcome  string
+		FastPath bool
+		Queued   bool
+		Dropped  bool
+	}{
+		{"fast", true, false, false},
+		// Rejected without being queued
+		{"dropped", false, false, true},
+		{"queued", false, true, false},
+	}
+
+	for n, tc := range cases {
+		s := ended[n]
+		attrs := s.Attributes()
+
+		if s.Name() != "congestion.Acquire" {
+			t.Errorf("Got span %q, expected %q", s.Name(), "congestion.Acquire")
+		}
+		if v := attr(attrs, "outcome").AsString(); v != tc.Outcome {
+			t.Errorf("Got outcome %q, expected %q", v, tc.Outcome)
+		}
+		if v := attr(attrs, "fast_path").AsBool(); v != tc.FastPath {
+			t.Errorf("Got fast_path %t, expected %t", v, tc.FastPath)
+		}
+		if v := attr(attrs, "queued").AsBool(); v != tc.Queued {
+			t.Errorf("Got queued %t, expected %t", v, tc.Queued)
+		}
+		if v := attr(attrs, "dropped").AsBool(); v != tc.Dropped {
+			t.Errorf("Got dropped %t, expected %t", v, tc.Dropped)
+		}
+		if v := attr(attrs, "priority").AsInt64(); v != 5 {
+			t.Errorf("Got priority %d, expected %d", v, 5)
+		}
+	}
+}
+
+func TestRetryEvents(t *testing.T) {
+	i, spans, _, tp := newTestInstrumentation(t)
+
+	l := congestion.New(congestion.Config{
+		Capacity: 10,
+		MaxLimit: 10,
+		Observer: i.Observer("upstream"),
+	})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "request")
+
+	b := congestion.Backoff{
+		Limiter:  &l,
+		Step:     time.Millisecond,
+		Priority: 1,
+	}
+
+	retried := false
+	for b.Try(ctx) {
+		if !retried {
+			retried = true
+			continue
+		}
+		break
+	}
+	b.Close()
+	span.End()
+
+	var request sdktrace.ReadOnlySpan
+	for _, s := range spans.Ended() {
+		if s.Name() == "request" {
+			request = s
+		}
+	}
+
+	if request == nil {
+		t.Fatal("Didn't record the request span")
+	}
+
+	events := request.Events()
+	if len(events) != 1 {
+		t.Fatalf("Got %d events, expected %d", len(events), 1)
+	}
+
+	e := events[0]
+	if v := attr(e.Attributes, "retry").AsInt64(); v != 1 {
+		t.Errorf("Got retry %d, expected %d", v, 1)
+	}
+	if v := attr(e.Attributes, "priority").AsInt64(); v != 2 {
+		t.Errorf("Got priority %d, expected %d", v, 2)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	i, _, reader, _ := newTestInstrumentation(t)
+
+	l := congestion.New(congestion.Config{
+		Capacity: 10,
+		MaxLimit: 10,
+		Observer: i.Observer("upstream"),
+	})
+	i.Add("upstream", &l)
+
+	err := l.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	defer l.Release()
+
+	var rm metricdata.ResourceMetrics
+	err = reader.Collect(context.Background(), &rm)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	gauges := map[string]int64{}
+	histograms := 0
+
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Gauge[int64]:
+				for _, p := range data.DataPoints {
+					gauges[m.Name] = p.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, p := range data.DataPoints {
+					histograms += int(p.Count)
+				}
+			}
+		}
+	}
+
+	if gauges["congestion.limiter.outstanding"] != 1 {
+		t.Errorf("Got outstanding %d, expected %d", gauges["congestion.limiter.outstanding"], 1)
+	}
+	if gauges["congestion.limiter.limit"] != 1 {
+		t.Errorf("Got limit %d, expected %d", gauges["congestion.limiter.limit"], 1)
+	}
+	if histograms != 1 {
+		t.Errorf("Got %d wait samples, expected %d", histograms, 1)
+	}
+
+	err = i.Close()
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+}
@@ -0,0 +1,47 @@
+package congestion
+
+import "time"
+
+// BackoffUntil signals that the upstream can't take requests until the
+// given time, e.g. from a Retry-After header. Nothing is admitted until
+// then, while waiters still honor their contexts. Unlike Backoff, this
+// doesn't decrease the limit, since the upstream has said exactly how
+// long to wait.
+func (l *Limiter) BackoffUntil(until time.Time) {
+	l.mu.Lock()
+
+	l.counters.backoffs++
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+
+		if l.pauseTimer == nil {
+			l.pauseTimer = time.AfterFunc(time.Until(until), l.resume)
+		} else {
+			l.pauseTimer.Reset(time.Until(until))
+		}
+	}
+
+	l.mu.Unlock()
+}
+
+// paused returns if the Limiter isn't admitting anything.
+func (l *Limiter) paused() bool {
+	if l.pausedUntil.IsZero() {
+		return false
+	}
+
+	if time.Now().Before(l.pausedUntil) {
+		return true
+	}
+
+	l.pausedUntil = time.Time{}
+	return false
+}
+
+// resume admitting waiters once the pause has passed.
+func (l *Limiter) resume() {
+	l.mu.Lock()
+	l.dispatch()
+	l.unlock()
+}
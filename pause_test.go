@@ -0,0 +1,56 @@
+package congestion
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffUntil(t *testing.T) {
+	const pause = 20 * time.Millisecond
+
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+	c.BackoffUntil(time.Now().Add(pause))
+
+	if c.limit != 1 {
+		t.Errorf("Got limit %d, expected it unchanged at %d", c.limit, 1)
+	}
+
+	// A waiter whose context ends during the pause gives up
+	ctx, cancel := context.WithTimeout(context.Background(), pause/4)
+	defer cancel()
+
+	err := c.Acquire(ctx, 0)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Got %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	// Otherwise it is admitted once the pause ends
+	start := time.Now()
+
+	err = c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	defer c.Release()
+
+	if waited := time.Since(start); waited < pause/2 {
+		t.Errorf("Waited %s, expected to wait for the pause", waited)
+	}
+
+	if s := c.Stats(); !s.PausedUntil.IsZero() || s.Backoffs != 1 {
+		t.Errorf("Got %+v, expected an unpaused limiter with 1 backoff", s)
+	}
+}
+
+func TestBackoffUntilKeepsLatest(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+
+	later := time.Now().Add(time.Hour)
+	c.BackoffUntil(later)
+	c.BackoffUntil(time.Now().Add(time.Minute))
+
+	if s := c.Stats(); !s.PausedUntil.Equal(later) {
+		t.Errorf("Got paused until %s, expected %s", s.PausedUntil, later)
+	}
+}
@@ -0,0 +1,214 @@
+// Package promcongestion exports the state of congestion Limiters, and
+// the Backoffs using them, as Prometheus metrics.
+package promcongestion
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/joshbohde/congestion"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stages are the AIMD stages exported as labels
+var stages = []congestion.Stage{
+	congestion.SlowStart,
+	congestion.Waiting,
+	congestion.Increasing,
+	congestion.Recovering,
+}
+
+// Collector is a prometheus.Collector over one or more named Limiters.
+// Gauges and counters are read from Limiter.Stats when scraped, and the
+// queue wait time and Backoff metrics are recorded by the Observer for
+// each Limiter.
+type Collector struct {
+	mu       sync.Mutex
+	limiters map[string]*congestion.Limiter
+
+	limit         *prometheus.Desc
+	outstanding   *prometheus.Desc
+	queued        *prometheus.Desc
+	stage         *prometheus.Desc
+	acquisitions  *prometheus.Desc
+	fastPath      *prometheus.Desc
+	drops         *prometheus.Desc
+	cancellations *prometheus.Desc
+	backoffs      *prometheus.Desc
+
+	wait    *prometheus.HistogramVec
+	retries *prometheus.HistogramVec
+	closed  *prometheus.CounterVec
+}
+
+// NewCollector creates a Collector with metrics under the namespace.
+func NewCollector(namespace string) *Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "limiter", name),
+			help,
+			append([]string{"limiter"}, labels...),
+			nil,
+		)
+	}
+
+	return &Collector{
+		limiters: map[string]*congestion.Limiter{},
+
+		limit:         desc("limit", "Current concurrency limit."),
+		outstanding:   desc("outstanding", "Acquired tokens that haven't been released."),
+		queued:        desc("queued", "Waiters in the queue."),
+		stage:         desc("stage", "Current stage of the AIMD algorithm.", "stage"),
+		acquisitions:  desc("acquisitions_total", "Tokens acquired."),
+		fastPath:      desc("fast_path_total", "Tokens acquired without waiting in the queue."),
+		drops:         desc("drops_total", "Waiters never admitted, because the queue was full, they were shed, or they would miss their deadline."),
+		cancellations: desc("cancellations_total", "Waiters whose context ended while queued."),
+		backoffs:      desc("backoffs_total", "Backoff signals."),
+
+		wait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "limiter",
+			Name:      "wait_seconds",
+			Help:      "Time spent waiting in the queue.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"limiter", "outcome"}),
+
+		retries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "backoff",
+			Name:      "retries",
+			Help:      "Retries made by each Backoff.",
+			Buckets:   []float64{0, 1, 2, 3, 5, 8, 13},
+		}, []string{"limiter"}),
+
+		closed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "backoff",
+			Name:      "closed_total",
+			Help:      "Backoffs closed, by the error that stopped them.",
+		}, []string{"limiter", "result"}),
+	}
+}
+
+// Add a Limiter to be collected under the name. The Limiter should be
+// created with Observer(name) as its Config.Observer.
+func (c *Collector) Add(name string, l *congestion.Limiter) {
+	c.mu.Lock()
+	c.limiters[name] = l
+	c.mu.Unlock()
+}
+
+// Remove the Limiter collected under the name.
+func (c *Collector) Remove(name string) {
+	c.mu.Lock()
+	delete(c.limiters, name)
+	c.mu.Unlock()
+
+	c.wait.DeletePartialMatch(prometheus.Labels{"limiter": name})
+	c.retries.DeletePartialMatch(prometheus.Labels{"limiter": name})
+	c.closed.DeletePartialMatch(prometheus.Labels{"limiter": name})
+}
+
+// Observer returns a congestion.Observer recording metrics for the Limiter named name.
+func (c *Collector) Observer(name string) congestion.Observer {
+	return observer{
+		collector: c,
+		name:      name,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.limit
+	ch <- c.outstanding
+	ch <- c.queued
+	ch <- c.stage
+	ch <- c.acquisitions
+	ch <- c.fastPath
+	ch <- c.drops
+	ch <- c.cancellations
+	ch <- c.backoffs
+
+	c.wait.Describe(ch)
+	c.retries.Describe(ch)
+	c.closed.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	limiters := make(map[string]*congestion.Limiter, len(c.limiters))
+	for name, l := range c.limiters {
+		limiters[name] = l
+	}
+	c.mu.Unlock()
+
+	for name, l := range limiters {
+		s := l.Stats()
+
+		gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append([]string{name}, labels...)...)
+		}
+		counter := func(desc *prometheus.Desc, v uint64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), name)
+		}
+
+		gauge(c.limit, float64(s.Limit))
+		gauge(c.outstanding, float64(s.Outstanding))
+		gauge(c.queued, float64(s.Queued))
+
+		// Only the AIMD algorithm has stages
+		if s.Stage != 0 {
+			for _, stage := range stages {
+				v := 0.0
+				if stage == s.Stage {
+					v = 1
+				}
+				gauge(c.stage, v, stage.String())
+			}
+		}
+
+		counter(c.acquisitions, s.Acquisitions)
+		counter(c.fastPath, s.FastPath)
+		counter(c.drops, s.Drops)
+		counter(c.cancellations, s.Cancellations)
+		counter(c.backoffs, s.Backoffs)
+	}
+
+	c.wait.Collect(ch)
+	c.retries.Collect(ch)
+	c.closed.Collect(ch)
+}
+
+// observer records events for a single named Limiter
+type observer struct {
+	collector *Collector
+	name      string
+}
+
+func (o observer) Acquired(ctx context.Context, e congestion.AcquireEvent) {
+	o.collector.wait.WithLabelValues(o.name, e.Outcome.String()).Observe(e.Wait.Seconds())
+}
+
+func (o observer) Retried(ctx context.Context, e congestion.RetryEvent) {}
+
+func (o observer) Closed(e congestion.BackoffEvent) {
+	o.collector.retries.WithLabelValues(o.name).Observe(float64(e.Retries))
+	o.collector.closed.WithLabelValues(o.name, result(e.Err)).Inc()
+}
+
+// result is a low cardinality label for the error that stopped a Backoff
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, congestion.Dropped):
+		return "dropped"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	}
+	return "error"
+}
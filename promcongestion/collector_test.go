@@ -0,0 +1,94 @@
+package promcongestion
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshbohde/congestion"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector("test")
+
+	l := congestion.New(congestion.Config{
+		Capacity: 10,
+		MaxLimit: 10,
+		Observer: c.Observer("upstream"),
+	})
+	c.Add("upstream", &l)
+
+	b := congestion.Backoff{
+		Limiter: &l,
+		Step:    time.Millisecond,
+	}
+
+	// Retry once, then succeed
+	retried := false
+	for b.Try(context.Background()) {
+		if !retried {
+			retried = true
+			continue
+		}
+		break
+	}
+	b.Close()
+
+	expected := `
+# HELP test_limiter_acquisitions_total Tokens acquired.
+# TYPE test_limiter_acquisitions_total counter
+test_limiter_acquisitions_total{limiter="upstream"} 2
+# HELP test_limiter_backoffs_total Backoff signals.
+# TYPE test_limiter_backoffs_total counter
+test_limiter_backoffs_total{limiter="upstream"} 1
+# HELP test_limiter_limit Current concurrency limit.
+# TYPE test_limiter_limit gauge
+test_limiter_limit{limiter="upstream"} 1
+# HELP test_limiter_outstanding Acquired tokens that haven't been released.
+# TYPE test_limiter_outstanding gauge
+test_limiter_outstanding{limiter="upstream"} 0
+# HELP test_limiter_stage Current stage of the AIMD algorithm.
+# TYPE test_limiter_stage gauge
+test_limiter_stage{limiter="upstream",stage="increasing"} 1
+test_limiter_stage{limiter="upstream",stage="recovering"} 0
+test_limiter_stage{limiter="upstream",stage="slowStart"} 0
+test_limiter_stage{limiter="upstream",stage="waiting"} 0
+# HELP test_backoff_closed_total Backoffs closed, by the error that stopped them.
+# TYPE test_backoff_closed_total counter
+test_backoff_closed_total{limiter="upstream",result="success"} 1
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"test_limiter_acquisitions_total",
+		"test_limiter_backoffs_total",
+		"test_limiter_limit",
+		"test_limiter_outstanding",
+		"test_limiter_stage",
+		"test_backoff_closed_total",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(c, "test_limiter_wait_seconds"); n != 1 {
+		t.Errorf("Got %d wait histograms, expected %d", n, 1)
+	}
+
+	c.Remove("upstream")
+
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Errorf("Got %d metrics after removing, expected %d", n, 0)
+	}
+}
+
+func TestCollectorRegisters(t *testing.T) {
+	r := prometheus.NewPedanticRegistry()
+
+	err := r.Register(NewCollector("test"))
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+}
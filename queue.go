@@ -1,21 +1,47 @@
 package congestion
 
 import (
-	"container/heap"
+	"math/bits"
+	"time"
 )
 
-const maxInt = int((^uint(0)) >> 1)
-
 // rendezvouz is for returning context to the calling goroutine
 type rendezvouz struct {
 	priority int
-	index    int
-	errChan  chan error
+	// n is the number of slots the waiter needs
+	n int
+	// round orders waiters of the same priority, lowest first
+	round uint64
+	// tenant is who the round was scheduled for
+	tenant string
+	// deadline orders waiters in the same round, soonest first
+	deadline time.Time
+	// enqueued is when the waiter was queued
+	enqueued time.Time
+	// seq orders otherwise equal waiters, by when they were pushed
+	seq uint64
+	// older and newer link the waiters of the same priority by seq
+	older, newer *rendezvouz
+	index        int
+	errChan      chan error
+	// reservation is the Reservation the waiter is for, if any
+	reservation *Reservation
+}
+
+// Drop the waiter from the queue, for the reason.
+func (r rendezvouz) Drop(reason DropReason) {
+	r.fail(&DropError{Reason: reason, Priority: r.priority})
 }
 
-func (r rendezvouz) Drop() {
+// fail hands the waiter an error instead of a token. A reservation is
+// finished straight away, and gives back any slots it holds once its
+// result is taken.
+func (r rendezvouz) fail(err error) {
 	select {
-	case r.errChan <- Dropped:
+	case r.errChan <- err:
+		if r.reservation != nil {
+			r.reservation.finish()
+		}
 	default:
 	}
 }
@@ -24,104 +50,377 @@ func (r rendezvouz) Signal() {
 	close(r.errChan)
 }
 
-type queue []*rendezvouz
+// queue is a min-max heap of waiters, ordered by before. Even levels,
+// starting with the root, hold elements served before all of their
+// descendants, and odd levels elements served after all of them. So
+// the next to serve is the root, and the last is one of its children,
+// and both can be removed in O(log n).
+type queue struct {
+	items []*rendezvouz
+	// seq is the sequence number of the last waiter pushed
+	seq uint64
+	// lifo serves the newest of otherwise equal waiters first
+	lifo bool
+	// policy decides who is dropped when the queue is full
+	policy DropPolicy
+	// bands are the waiters queued at each priority
+	bands map[int]band
+}
+
+// band tracks the waiters queued at one priority.
+type band struct {
+	// waiters is how many are queued
+	waiters int
+	// slots is how many slots they need in total
+	slots int
+	// oldest and newest are the ends of its waiters, linked by seq
+	oldest, newest *rendezvouz
+}
+
+// enter records that r was added to the queue.
+func (pq *queue) enter(r *rendezvouz) {
+	if pq.bands == nil {
+		pq.bands = map[int]band{}
+	}
 
-func (pq queue) Len() int { return len(pq) }
+	b := pq.bands[r.priority]
+	b.waiters++
+	b.slots += r.n
 
-func (pq queue) Less(i, j int) bool {
+	// Waiters are entered in seq order, so r is the newest
+	r.older = b.newest
+	r.newer = nil
+	if b.newest != nil {
+		b.newest.newer = r
+	} else {
+		b.oldest = r
+	}
+	b.newest = r
+
+	pq.bands[r.priority] = b
+}
+
+// leave records that r was taken out of the queue.
+func (pq *queue) leave(r *rendezvouz) {
+	b := pq.bands[r.priority]
+	b.waiters--
+	b.slots -= r.n
+
+	if r.older != nil {
+		r.older.newer = r.newer
+	} else {
+		b.oldest = r.newer
+	}
+	if r.newer != nil {
+		r.newer.older = r.older
+	} else {
+		b.newest = r.older
+	}
+	r.older, r.newer = nil, nil
+
+	if b.waiters == 0 {
+		delete(pq.bands, r.priority)
+	} else {
+		pq.bands[r.priority] = b
+	}
+}
+
+// before returns if r should be served before other.
+func (pq *queue) before(r, other *rendezvouz) bool {
 	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
-	return pq[i].priority > pq[j].priority
+	if r.priority != other.priority {
+		return r.priority > other.priority
+	}
+	if r.round != other.round {
+		return r.round < other.round
+	}
+
+	// Waiters with a deadline go first, soonest first
+	if !r.deadline.Equal(other.deadline) {
+		return !r.deadline.IsZero() && (other.deadline.IsZero() || r.deadline.Before(other.deadline))
+	}
+
+	if pq.lifo {
+		return r.seq > other.seq
+	}
+	return r.seq < other.seq
+}
+
+// firstLevel returns if the index is on a level whose elements are
+// served before their descendants.
+func firstLevel(i int) bool {
+	return bits.Len(uint(i+1))%2 == 1
+}
+
+// ordered returns if the element at i belongs above the one at j on
+// first levels, or below it on last levels.
+func (pq *queue) ordered(i, j int, first bool) bool {
+	if first {
+		return pq.before(pq.items[i], pq.items[j])
+	}
+	return pq.before(pq.items[j], pq.items[i])
+}
+
+func (pq *queue) swap(i, j int) {
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
+}
+
+// up moves the element at i towards the root.
+func (pq *queue) up(i int) {
+	if i == 0 {
+		return
+	}
+
+	first := firstLevel(i)
+	parent := (i - 1) / 2
+
+	// If it belongs on the other kind of level, swap with the parent first
+	if pq.ordered(parent, i, first) {
+		pq.swap(i, parent)
+		i = parent
+		first = !first
+	}
+
+	// Then move up through the grandparents, on the same kind of level
+	for i > 2 {
+		grandparent := ((i-1)/2 - 1) / 2
+		if !pq.ordered(i, grandparent, first) {
+			break
+		}
+		pq.swap(i, grandparent)
+		i = grandparent
+	}
 }
 
-func (pq queue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[j].index = j
+// down moves the element at i away from the root.
+func (pq *queue) down(i int) {
+	first := firstLevel(i)
+	n := len(pq.items)
+
+	for {
+		// Find the most extreme of the children and grandchildren
+		m := -1
+		for _, c := range [...]int{2*i + 1, 2*i + 2, 4*i + 3, 4*i + 4, 4*i + 5, 4*i + 6} {
+			if c < n && (m < 0 || pq.ordered(c, m, first)) {
+				m = c
+			}
+		}
+
+		if m < 0 || !pq.ordered(m, i, first) {
+			return
+		}
+
+		pq.swap(m, i)
+
+		// Children are on the other kind of level, so we are done
+		if m <= 2*i+2 {
+			return
+		}
+
+		// The element moved down from i may belong on its new parent's level
+		if parent := (m - 1) / 2; pq.ordered(parent, m, first) {
+			pq.swap(m, parent)
+		}
+
+		i = m
+	}
 }
 
-func (pq *queue) Push(x interface{}) {
-	n := len(*pq)
-	item := x.(*rendezvouz)
-	item.index = n
-	*pq = append(*pq, item)
+// fix restores the heap after the element at i changed. If it moved
+// up past its parent, the parent is now at i, and may need to move down.
+func (pq *queue) fix(i int) {
+	pq.up(i)
+	pq.down(i)
 }
 
-func (pq *queue) Pop() interface{} {
-	old := *pq
-	n := len(old)
-	item := old[n-1]
-	item.index = -1 // for safety
-	*pq = old[0 : n-1]
-	return item
+// remove the element at i.
+func (pq *queue) remove(i int) *rendezvouz {
+	last := len(pq.items) - 1
+	if i != last {
+		pq.swap(i, last)
+	}
+
+	r := pq.items[last]
+	r.index = -1 // for safety
+	pq.items[last] = nil
+	pq.items = pq.items[:last]
+	pq.leave(r)
+
+	if i < last {
+		pq.fix(i)
+	}
+
+	return r
 }
 
 type priorityQueue queue
 
 func newQueue(capacity int) priorityQueue {
-	return priorityQueue(make([]*rendezvouz, 0, capacity))
+	return priorityQueue{
+		items: make([]*rendezvouz, 0, capacity),
+	}
 }
 
 func (pq *priorityQueue) Len() int {
-	return len(*pq)
+	return len(pq.items)
 }
 
 func (pq *priorityQueue) Cap() int {
-	return cap(*pq)
+	return cap(pq.items)
 }
 
 func (pq *priorityQueue) push(r *rendezvouz) {
-	heap.Push((*queue)(pq), r)
+	r.index = len(pq.items)
+	pq.items = append(pq.items, r)
+	(*queue)(pq).enter(r)
+	(*queue)(pq).up(r.index)
 }
 
 func (pq *priorityQueue) Push(r *rendezvouz) bool {
+	pq.seq++
+	r.seq = pq.seq
+
 	// If we're under capacity, push it to the queue
 	if pq.Len() < pq.Cap() {
 		pq.push(r)
 		return true
 	}
 
-	// otherwise, we need to check if this takes priority over the lowest element
-	old := *pq
-	n := len(old)
-	index := n / 2
+	if pq.Len() == 0 {
+		return false
+	}
+
+	// otherwise, we need to check if this displaces another element
+	victim := pq.victim(r)
+	if victim < 0 {
+		return false
+	}
+
+	last := pq.items[victim]
+	pq.items[victim] = r
+	(*queue)(pq).leave(last)
+	(*queue)(pq).enter(r)
+
+	// Fix index
+	r.index = victim
+	(*queue)(pq).fix(victim)
+
+	// For safety
+	last.index = -1
+	last.fail(&DropError{Reason: Evicted, Priority: last.priority, DisplacedBy: r.priority})
+
+	return true
+}
 
-	lowestIndex := index
-	priority := maxInt
+// victim returns the index of the element r displaces in a full queue,
+// or -1 if r is rejected instead. DropNewest and DropOldest look at the
+// ends of each priority's waiters, so they cost one step per priority
+// queued, not per waiter.
+func (pq *priorityQueue) victim(r *rendezvouz) int {
+	switch pq.policy {
+	case RejectIncoming:
+		return -1
 
-	for i := index; i < n; i++ {
-		if old[i].priority < priority {
-			lowestIndex = i
-			priority = old[i].priority
+	case DropNewest, DropOldest:
+		// Never displace a higher priority
+		var victim *rendezvouz
+		for priority, b := range pq.bands {
+			if priority > r.priority {
+				continue
+			}
+
+			if pq.policy == DropNewest && (victim == nil || b.newest.seq > victim.seq) {
+				victim = b.newest
+			}
+			if pq.policy == DropOldest && (victim == nil || b.oldest.seq < victim.seq) {
+				victim = b.oldest
+			}
+		}
+
+		if victim == nil {
+			return -1
 		}
+		return victim.index
 	}
 
-	last := (*pq)[lowestIndex]
-	if last.priority < r.priority {
-		(*pq)[lowestIndex] = r
+	lowest := pq.lowest()
+	if (*queue)(pq).before(r, pq.items[lowest]) {
+		return lowest
+	}
+	return -1
+}
 
-		// Fix index
-		r.index = lowestIndex
-		heap.Fix((*queue)(pq), lowestIndex)
+// lowest returns the index of the element served last, which is the
+// root if it is alone, or else one of its children.
+func (pq *priorityQueue) lowest() int {
+	switch pq.Len() {
+	case 1:
+		return 0
+	case 2:
+		return 1
+	}
 
-		// For safety
-		last.index = -1
-		last.Drop()
+	if (*queue)(pq).before(pq.items[1], pq.items[2]) {
+		return 2
+	}
+	return 1
+}
 
-		return true
+// SetCap changes the capacity of the queue, dropping the lowest
+// priority elements that no longer fit.
+func (pq *priorityQueue) SetCap(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	for pq.Len() > capacity {
+		pq.DropLowest()
 	}
 
-	return false
+	resized := make([]*rendezvouz, pq.Len(), capacity)
+	copy(resized, pq.items)
+	pq.items = resized
+}
+
+// SetLIFO changes whether the newest of otherwise equal elements are
+// served first, reordering the queue if it changed.
+func (pq *priorityQueue) SetLIFO(lifo bool) {
+	if pq.lifo == lifo {
+		return
+	}
+
+	pq.lifo = lifo
+	for i := pq.Len()/2 - 1; i >= 0; i-- {
+		(*queue)(pq).down(i)
+	}
+}
+
+// LIFO returns if the newest of otherwise equal elements are served first.
+func (pq *priorityQueue) LIFO() bool {
+	return pq.lifo
+}
+
+// DropLowest drops the element that would be served last.
+func (pq *priorityQueue) DropLowest() {
+	r := (*queue)(pq).remove(pq.lowest())
+	r.Drop(Shed)
 }
 
 func (pq *priorityQueue) Empty() bool {
-	return (*queue)(pq).Len() <= 0
+	return pq.Len() <= 0
+}
+
+// Peek returns the highest priority element without removing it.
+func (pq *priorityQueue) Peek() *rendezvouz {
+	return pq.items[0]
 }
 
 func (pq *priorityQueue) Pop() rendezvouz {
-	ret := heap.Pop((*queue)(pq)).(*rendezvouz)
-	return *ret
+	return *(*queue)(pq).remove(0)
 }
 
 func (pq *priorityQueue) Remove(r *rendezvouz) {
-	heap.Remove((*queue)(pq), r.index)
+	(*queue)(pq).remove(r.index)
 }
@@ -1,6 +1,7 @@
 package congestion
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -78,7 +79,7 @@ func TestDropLast(t *testing.T) {
 			default:
 			}
 
-			if dropped != Dropped {
+			if !errors.Is(dropped, Dropped) {
 				t.Errorf("Got %d, expected %d", dropped, Dropped)
 			}
 		})
@@ -86,6 +87,83 @@ func TestDropLast(t *testing.T) {
 
 }
 
+func TestSetCap(t *testing.T) {
+	rs := make([]rendezvouz, 5)
+
+	q := newQueue(5)
+	for i := range rs {
+		rs[i] = rendezvouz{priority: i, errChan: make(chan error, 1)}
+		q.Push(&rs[i])
+	}
+
+	q.SetCap(3)
+
+	if q.Len() != 3 || q.Cap() != 3 {
+		t.Errorf("Got len=%d cap=%d, expected len=%d cap=%d", q.Len(), q.Cap(), 3, 3)
+	}
+
+	for i, r := range rs {
+		var dropped error
+
+		select {
+		case dropped = <-r.errChan:
+		default:
+		}
+
+		if i < 2 && !errors.Is(dropped, Dropped) {
+			t.Errorf("Priority %d got %v, expected %v", i, dropped, Dropped)
+		}
+		if i >= 2 && dropped != nil {
+			t.Errorf("Priority %d got %v, expected it to stay queued", i, dropped)
+		}
+	}
+
+	q.SetCap(10)
+
+	if q.Len() != 3 || q.Cap() != 10 {
+		t.Errorf("Got len=%d cap=%d, expected len=%d cap=%d", q.Len(), q.Cap(), 3, 10)
+	}
+
+	if r := q.Pop(); r.priority != 4 {
+		t.Errorf("Got %d, expected %d", r.priority, 4)
+	}
+}
+
+func TestSetLIFO(t *testing.T) {
+	rs := make([]rendezvouz, 4)
+
+	q := newQueue(10)
+	for i := range rs {
+		rs[i] = rendezvouz{priority: i / 2}
+		q.Push(&rs[i])
+	}
+
+	q.SetLIFO(true)
+
+	// Priority still comes first, then the newest
+	for _, expected := range []uint64{rs[3].seq, rs[2].seq} {
+		if r := q.Pop(); r.seq != expected {
+			t.Errorf("Got seq %d, expected %d", r.seq, expected)
+		}
+	}
+
+	q.SetLIFO(false)
+
+	for _, expected := range []uint64{rs[0].seq, rs[1].seq} {
+		if r := q.Pop(); r.seq != expected {
+			t.Errorf("Got seq %d, expected %d", r.seq, expected)
+		}
+	}
+}
+
+func TestPushZeroCapacity(t *testing.T) {
+	q := newQueue(0)
+
+	if q.Push(&rendezvouz{}) {
+		t.Error("Expected push to fail")
+	}
+}
+
 func BenchmarkQueue(b *testing.B) {
 	b.Run("newQueue", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
@@ -157,6 +235,85 @@ func BenchmarkQueue(b *testing.B) {
 		})
 	})
 
+	b.Run("Evict", func(b *testing.B) {
+		for _, capacity := range []int{1000, 10000, 100000} {
+			capacity := capacity
+			b.Run(fmt.Sprintf("%d", capacity), func(b *testing.B) {
+				q := newQueue(capacity)
+
+				// Each push is higher priority than everything queued,
+				// so it evicts the lowest. The evicted one is reused next.
+				rs := make([]rendezvouz, capacity+1)
+				for i := 0; i < capacity; i++ {
+					rs[i].priority = i
+					q.Push(&rs[i])
+				}
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					r := &rs[(capacity+i)%len(rs)]
+					r.priority = capacity + i
+					q.Push(r)
+				}
+			})
+		}
+	})
+
+	b.Run("DropNewest", func(b *testing.B) {
+		for _, capacity := range []int{1000, 10000, 100000} {
+			capacity := capacity
+			b.Run(fmt.Sprintf("%d", capacity), func(b *testing.B) {
+				q := newQueue(capacity)
+				q.policy = DropNewest
+
+				// Waiters are spread over a few priorities
+				rs := make([]rendezvouz, capacity+1)
+				for i := 0; i < capacity; i++ {
+					rs[i].priority = i % 8
+					q.Push(&rs[i])
+				}
+
+				// Each push evicts the one before it, which is reused next
+				spare, newest := &rs[capacity], &rs[capacity-1]
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					spare.priority = 8
+					q.Push(spare)
+					spare, newest = newest, spare
+				}
+			})
+		}
+	})
+
+	b.Run("DropOldest", func(b *testing.B) {
+		for _, capacity := range []int{1000, 10000, 100000} {
+			capacity := capacity
+			b.Run(fmt.Sprintf("%d", capacity), func(b *testing.B) {
+				q := newQueue(capacity)
+				q.policy = DropOldest
+
+				// Waiters are spread over a few priorities, and each
+				// push evicts the oldest. The evicted one is reused next.
+				rs := make([]rendezvouz, capacity+1)
+				for i := 0; i < capacity; i++ {
+					rs[i].priority = i % 8
+					q.Push(&rs[i])
+				}
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					r := &rs[(capacity+i)%len(rs)]
+					r.priority = i % 8
+					q.Push(r)
+				}
+			})
+		}
+	})
+
 	b.Run("Pop", func(b *testing.B) {
 		q := newQueue(b.N)
 
@@ -192,6 +349,7 @@ type queueMachine struct {
 func (m *queueMachine) Init(t *rapid.T) {
 	n := rapid.IntRange(1, 3).Draw(t, "n").(int)
 	q := newQueue(n)
+	q.policy = DropPolicy(rapid.IntRange(int(DropLowestPriority), int(RejectIncoming)).Draw(t, "policy").(int))
 	m.q = &q
 	m.n = n
 }
@@ -200,9 +358,16 @@ func (m *queueMachine) Init(t *rapid.T) {
 func (m *queueMachine) Push(t *rapid.T) {
 	r := rendezvouz{
 		priority: rapid.Int().Draw(t, "priority").(int),
+		n:        rapid.IntRange(1, 3).Draw(t, "slots").(int),
 		errChan:  make(chan error, 1),
 	}
 
+	if m.q.Len() == m.q.Cap() && (m.q.policy == DropNewest || m.q.policy == DropOldest) {
+		if victim, expected := m.q.victim(&r), scanVictim(m.q, &r); victim != expected {
+			t.Fatalf("Got victim %d, expected %d", victim, expected)
+		}
+	}
+
 	m.q.Push(&r)
 }
 
@@ -212,7 +377,7 @@ func (m *queueMachine) Remove(t *rapid.T) {
 		t.Skip("empty")
 	}
 
-	r := (*m.q)[rapid.IntRange(0, m.q.Len()-1).Draw(t, "i").(int)]
+	r := m.q.items[rapid.IntRange(0, m.q.Len()-1).Draw(t, "i").(int)]
 	m.q.Remove(r)
 }
 
@@ -222,8 +387,8 @@ func (m *queueMachine) Drop(t *rapid.T) {
 		t.Skip("empty")
 	}
 
-	r := (*m.q)[rapid.IntRange(0, m.q.Len()-1).Draw(t, "i").(int)]
-	r.Drop()
+	r := m.q.items[rapid.IntRange(0, m.q.Len()-1).Draw(t, "i").(int)]
+	r.Drop(Shed)
 }
 
 // Model of Signal
@@ -236,18 +401,101 @@ func (m *queueMachine) Pop(t *rapid.T) {
 	r.Signal()
 }
 
+// scanVictim finds the waiter DropNewest or DropOldest displaces by
+// looking at every waiter.
+func scanVictim(q *priorityQueue, r *rendezvouz) int {
+	victim := -1
+	for i, w := range q.items {
+		if w.priority > r.priority {
+			continue
+		}
+
+		if victim < 0 ||
+			(q.policy == DropNewest && w.seq > q.items[victim].seq) ||
+			(q.policy == DropOldest && w.seq < q.items[victim].seq) {
+			victim = i
+		}
+	}
+	return victim
+}
+
+// checkHeap fails unless elements on first levels are served before
+// all of their descendants, and those on last levels after them.
+func checkHeap(t *rapid.T, q *priorityQueue) {
+	for i, r := range q.items {
+		for a := (i - 1) / 2; i > 0; a = (a - 1) / 2 {
+			ancestor := q.items[a]
+
+			if firstLevel(a) && (*queue)(q).before(r, ancestor) {
+				t.Fatalf("%d is served before its ancestor %d on a first level", i, a)
+			}
+			if !firstLevel(a) && (*queue)(q).before(ancestor, r) {
+				t.Fatalf("%d is served after its ancestor %d on a last level", i, a)
+			}
+
+			if a == 0 {
+				break
+			}
+		}
+	}
+}
+
 // validate that invariants hold
 func (m *queueMachine) Check(t *rapid.T) {
+	checkHeap(t, m.q)
+
 	if m.q.Len() > m.q.Cap() {
 		t.Fatalf("queue over capacity: %v vs expected %v", m.q.Len(), m.q.Cap())
 	}
 
-	for i, r := range *m.q {
+	for i, r := range m.q.items {
 		if r.index != i {
 			t.Fatalf("illegal index: expected %d, got %+v ", i, r)
 		}
 	}
 
+	checkBands(t, m.q)
+}
+
+// checkBands fails unless the bands match the waiters queued at each priority.
+func checkBands(t *rapid.T, q *priorityQueue) {
+	expected := map[int]band{}
+	for _, r := range q.items {
+		b := expected[r.priority]
+		b.waiters++
+		b.slots += r.n
+		expected[r.priority] = b
+	}
+
+	if len(q.bands) != len(expected) {
+		t.Fatalf("Got %d bands, expected %d", len(q.bands), len(expected))
+	}
+
+	for priority, b := range q.bands {
+		if b.waiters != expected[priority].waiters || b.slots != expected[priority].slots {
+			t.Fatalf("Got %d waiters needing %d slots at priority %d, expected %d and %d",
+				b.waiters, b.slots, priority, expected[priority].waiters, expected[priority].slots)
+		}
+
+		// The waiters are linked from oldest to newest
+		n := 0
+		for r := b.oldest; r != nil; r = r.newer {
+			if r.priority != priority || r.index < 0 || q.items[r.index] != r {
+				t.Fatalf("Got a linked waiter %+v at priority %d that isn't queued there", r, priority)
+			}
+			if r.newer != nil && r.newer.seq <= r.seq {
+				t.Fatalf("Got waiters linked out of order at priority %d", priority)
+			}
+			if r.newer == nil && r != b.newest {
+				t.Fatalf("Got a different newest waiter at priority %d", priority)
+			}
+			n++
+		}
+
+		if n != b.waiters {
+			t.Fatalf("Got %d linked waiters at priority %d, expected %d", n, priority, b.waiters)
+		}
+	}
 }
 
 func TestPriorityQueue(t *testing.T) {
@@ -255,3 +503,150 @@ func TestPriorityQueue(t *testing.T) {
 		rapid.Check(t, rapid.Run(&queueMachine{}))
 	})
 }
+
+// fifoMachine checks the queue against a model that serves the highest
+// priority first, and the oldest first within a priority.
+type fifoMachine struct {
+	q      *priorityQueue
+	queued []*rendezvouz // model of the queue, in the order pushed
+}
+
+func (m *fifoMachine) Init(t *rapid.T) {
+	q := newQueue(rapid.IntRange(1, 40).Draw(t, "n").(int))
+	m.q = &q
+	m.queued = nil
+}
+
+// best returns the index of the waiter the model serves first
+func (m *fifoMachine) best() int {
+	best := 0
+	for i, r := range m.queued {
+		if r.priority > m.queued[best].priority {
+			best = i
+		}
+	}
+	return best
+}
+
+// worst returns the index of the waiter the model serves last
+func (m *fifoMachine) worst() int {
+	worst := 0
+	for i, r := range m.queued {
+		if r.priority <= m.queued[worst].priority {
+			worst = i
+		}
+	}
+	return worst
+}
+
+func (m *fifoMachine) remove(i int) {
+	m.queued = append(m.queued[:i], m.queued[i+1:]...)
+}
+
+func (m *fifoMachine) Push(t *rapid.T) {
+	r := &rendezvouz{
+		priority: rapid.IntRange(0, 2).Draw(t, "priority").(int),
+		errChan:  make(chan error, 1),
+	}
+
+	full := m.q.Len() == m.q.Cap()
+
+	var worst int
+	var displaced *rendezvouz
+	if full {
+		worst = m.worst()
+		displaced = m.queued[worst]
+	}
+
+	pushed := m.q.Push(r)
+
+	switch {
+	case !full:
+		if !pushed {
+			t.Fatalf("Push failed under capacity")
+		}
+	case r.priority > displaced.priority:
+		if !pushed || displaced.index != -1 {
+			t.Fatalf("Expected priority %d to displace %d", r.priority, displaced.priority)
+		}
+		m.remove(worst)
+	default:
+		// The newcomer is the youngest, so it can only displace a lower priority
+		if pushed {
+			t.Fatalf("Expected priority %d not to displace %d", r.priority, displaced.priority)
+		}
+		return
+	}
+
+	m.queued = append(m.queued, r)
+}
+
+func (m *fifoMachine) Remove(t *rapid.T) {
+	if len(m.queued) == 0 {
+		t.Skip("empty")
+	}
+
+	i := rapid.IntRange(0, len(m.queued)-1).Draw(t, "i").(int)
+	m.q.Remove(m.queued[i])
+	m.remove(i)
+}
+
+func (m *fifoMachine) Pop(t *rapid.T) {
+	if len(m.queued) == 0 {
+		t.Skip("empty")
+	}
+
+	best := m.best()
+	expected := m.queued[best]
+	m.remove(best)
+
+	r := m.q.Pop()
+	if r.seq != expected.seq {
+		t.Fatalf("Got priority %d seq %d, expected priority %d seq %d", r.priority, r.seq, expected.priority, expected.seq)
+	}
+}
+
+func (m *fifoMachine) Check(t *rapid.T) {
+	checkHeap(t, m.q)
+
+	if m.q.Len() != len(m.queued) {
+		t.Fatalf("Got %d queued, expected %d", m.q.Len(), len(m.queued))
+	}
+}
+
+func TestFIFOWithinPriority(t *testing.T) {
+	rapid.Check(t, rapid.Run(&fifoMachine{}))
+}
+
+func TestFIFOReplaceLowest(t *testing.T) {
+	rs := make([]rendezvouz, 3)
+
+	q := newQueue(3)
+	for i := range rs {
+		rs[i] = rendezvouz{priority: 0, errChan: make(chan error, 1)}
+		q.Push(&rs[i])
+	}
+
+	// The newest of the lowest priority is replaced
+	high := rendezvouz{priority: 1, errChan: make(chan error, 1)}
+	if !q.Push(&high) {
+		t.Fatal("Expected push to succeed")
+	}
+
+	var dropped error
+
+	select {
+	case dropped = <-rs[2].errChan:
+	default:
+	}
+
+	if !errors.Is(dropped, Dropped) {
+		t.Errorf("Got %v, expected the newest to be dropped", dropped)
+	}
+
+	for _, expected := range []uint64{high.seq, rs[0].seq, rs[1].seq} {
+		if r := q.Pop(); r.seq != expected {
+			t.Errorf("Got seq %d, expected %d", r.seq, expected)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package congestion
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Registry lazily creates a Limiter per key from a Config template,
+// e.g. one per upstream host. Limiters that are idle, with nothing
+// outstanding or queued, are evicted when the Registry grows past
+// MaxSize, or when they haven't been used for TTL.
+//
+// A Limiter returned by Get may be evicted before it's acquired, in
+// which case the next Get for the key creates a new one, and the
+// limit it learned is lost. Use Acquire to get and acquire a key's
+// Limiter without that race.
+type Registry struct {
+	// Config is the template for each key's Limiter
+	Config Config
+	// MaxSize is the most Limiters kept. Past it the least recently
+	// used idle Limiters are evicted. Zero is unlimited.
+	MaxSize int
+	// TTL evicts idle Limiters that haven't been used for this long. Zero disables it.
+	TTL time.Duration
+	// WarmStart is how many evicted keys have their learned limit
+	// remembered, so their next Limiter starts there instead of at
+	// Config.InitialLimit. Zero disables it.
+	WarmStart int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List
+	learned map[string]*list.Element
+	warm    list.List
+}
+
+// registryEntry is a key's Limiter, or its learned limit after eviction
+type registryEntry struct {
+	key      string
+	limiter  *Limiter
+	limit    int
+	lastUsed time.Time
+	// pins are callers between finding the Limiter and acquiring it
+	pins int
+}
+
+// NewRegistry creates a Registry of Limiters created from the Config.
+func NewRegistry(cfg Config) *Registry {
+	return &Registry{
+		Config: cfg,
+	}
+}
+
+// Get returns the Limiter for the key, creating it if needed.
+func (r *Registry) Get(key string) *Limiter {
+	r.mu.Lock()
+	l := r.entry(key).limiter
+	r.mu.Unlock()
+
+	return l
+}
+
+// Acquire acquires the Limiter for the key, creating it if needed, and
+// returns it so the caller can release it. The Limiter can't be evicted
+// while it's being acquired.
+func (r *Registry) Acquire(ctx context.Context, key string, priority int) (*Limiter, error) {
+	r.mu.Lock()
+	entry := r.entry(key)
+	entry.pins++
+	r.mu.Unlock()
+
+	err := entry.limiter.Acquire(ctx, priority)
+
+	// Once acquired, the Limiter isn't idle, so it's safe to unpin
+	r.mu.Lock()
+	entry.pins--
+	r.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.limiter, nil
+}
+
+// entry returns the key's entry, creating it if needed. It must be
+// called with the lock held.
+func (r *Registry) entry(key string) *registryEntry {
+	now := time.Now()
+
+	if r.entries == nil {
+		r.entries = map[string]*list.Element{}
+		r.learned = map[string]*list.Element{}
+	}
+
+	if e, ok := r.entries[key]; ok {
+		e.Value.(*registryEntry).lastUsed = now
+		r.lru.MoveToFront(e)
+		r.evict(now)
+		return e.Value.(*registryEntry)
+	}
+
+	cfg := r.Config
+	if e, ok := r.learned[key]; ok {
+		cfg.InitialLimit = e.Value.(*registryEntry).limit
+		r.warm.Remove(e)
+		delete(r.learned, key)
+	}
+
+	l := New(cfg)
+	entry := &registryEntry{
+		key:      key,
+		limiter:  &l,
+		lastUsed: now,
+	}
+	r.entries[key] = r.lru.PushFront(entry)
+
+	r.evict(now)
+	return entry
+}
+
+// Len returns the number of Limiters in the Registry.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	n := r.lru.Len()
+	r.mu.Unlock()
+
+	return n
+}
+
+// evict idle Limiters that are expired, or past MaxSize, starting from
+// the least recently used. The most recently used, and those being
+// acquired, are never evicted.
+func (r *Registry) evict(now time.Time) {
+	for e := r.lru.Back(); e != nil && e != r.lru.Front(); {
+		entry := e.Value.(*registryEntry)
+		prev := e.Prev()
+
+		expired := r.TTL > 0 && now.Sub(entry.lastUsed) > r.TTL
+		over := r.MaxSize > 0 && r.lru.Len() > r.MaxSize
+
+		// Entries are ordered by use, so nothing further can be expired
+		if !expired && !over {
+			return
+		}
+
+		if entry.pins == 0 && entry.limiter.idle() {
+			r.lru.Remove(e)
+			delete(r.entries, entry.key)
+			r.remember(entry)
+		}
+
+		e = prev
+	}
+}
+
+// remember the learned limit of an evicted entry, if warm starts are enabled.
+func (r *Registry) remember(entry *registryEntry) {
+	if r.WarmStart <= 0 {
+		return
+	}
+
+	entry.limit = entry.limiter.Stats().Limit
+	entry.limiter = nil
+	r.learned[entry.key] = r.warm.PushFront(entry)
+
+	for r.warm.Len() > r.WarmStart {
+		oldest := r.warm.Back()
+		r.warm.Remove(oldest)
+		delete(r.learned, oldest.Value.(*registryEntry).key)
+	}
+}
+
+// idle returns if the Limiter has nothing outstanding or queued.
+func (l *Limiter) idle() bool {
+	l.mu.Lock()
+	idle := l.outstanding == 0 && l.waiters.Len() == 0
+	l.mu.Unlock()
+
+	return idle
+}
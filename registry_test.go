@@ -0,0 +1,169 @@
+package congestion
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+
+	a := r.Get("a")
+	if r.Get("a") != a {
+		t.Error("Got a different limiter for the same key")
+	}
+
+	if r.Get("b") == a {
+		t.Error("Got the same limiter for different keys")
+	}
+
+	if r.Len() != 2 {
+		t.Errorf("Got %d limiters, expected %d", r.Len(), 2)
+	}
+}
+
+func TestRegistryEvictsLRU(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+	r.MaxSize = 2
+
+	a := r.Get("a")
+	b := r.Get("b")
+
+	// Use a, so b is the least recently used
+	r.Get("a")
+	r.Get("c")
+
+	if r.Len() != 2 {
+		t.Errorf("Got %d limiters, expected %d", r.Len(), 2)
+	}
+	if r.Get("a") != a {
+		t.Error("Evicted the most recently used limiter")
+	}
+	if r.Get("b") == b {
+		t.Error("Didn't evict the least recently used limiter")
+	}
+}
+
+func TestRegistryKeepsBusy(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+	r.MaxSize = 1
+
+	a := r.Get("a")
+
+	err := a.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	r.Get("b")
+
+	if r.Len() != 2 {
+		t.Errorf("Got %d limiters, expected the busy limiter to be kept", r.Len())
+	}
+
+	a.Release()
+	r.Get("b")
+
+	if r.Len() != 1 {
+		t.Errorf("Got %d limiters, expected the idle limiter to be evicted", r.Len())
+	}
+}
+
+func TestRegistryAcquire(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+	r.MaxSize = 1
+
+	a, err := r.Acquire(context.Background(), "a", 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if r.Get("a") != a {
+		t.Error("Got a different limiter for the same key")
+	}
+	if s := a.Stats(); s.Outstanding != 1 {
+		t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 1)
+	}
+
+	// It's busy, so it's kept
+	r.Get("b")
+	if r.Get("a") != a {
+		t.Error("Evicted an acquired limiter")
+	}
+
+	a.Release()
+}
+
+func TestRegistryKeepsPinned(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+	r.MaxSize = 1
+
+	// a is idle, but being acquired
+	r.mu.Lock()
+	entry := r.entry("a")
+	entry.pins++
+	r.mu.Unlock()
+
+	r.Get("b")
+
+	if r.Get("a") != entry.limiter {
+		t.Error("Evicted a limiter being acquired")
+	}
+}
+
+func TestRegistryTTL(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+	r.TTL = time.Millisecond
+
+	a := r.Get("a")
+	time.Sleep(2 * time.Millisecond)
+	r.Get("b")
+
+	if r.Len() != 1 {
+		t.Errorf("Got %d limiters, expected %d", r.Len(), 1)
+	}
+	if r.Get("a") == a {
+		t.Error("Didn't evict the expired limiter")
+	}
+}
+
+func TestRegistryWarmStart(t *testing.T) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 100})
+	r.MaxSize = 1
+	r.WarmStart = 1
+
+	a := r.Get("a")
+	a.mu.Lock()
+	a.limit = 42
+	a.mu.Unlock()
+
+	// Evict a, then b, which forgets a
+	r.Get("b")
+	if l := r.Get("a").Stats().Limit; l != 42 {
+		t.Errorf("Got limit %d, expected the learned limit %d", l, 42)
+	}
+
+	r.Get("b")
+	r.Get("c")
+	if l := r.Get("a").Stats().Limit; l != 1 {
+		t.Errorf("Got limit %d, expected the forgotten key to start at %d", l, 1)
+	}
+}
+
+func BenchmarkRegistry(b *testing.B) {
+	r := NewRegistry(Config{Capacity: 10, MaxLimit: 10})
+	r.MaxSize = 1000
+
+	keys := make([]string, 2000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("host-%d", i)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r.Get(keys[i%len(keys)])
+	}
+}
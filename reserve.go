@@ -0,0 +1,284 @@
+package congestion
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TryAcquire acquires a Lock only if it can be done without waiting,
+// returning if it was acquired. Nothing is queued, so the caller can go
+// do something else when the Limiter is full. If the Limiter has a
+// parent, that must be free too.
+func (l *Limiter) TryAcquire(priority int) bool {
+	if !l.tryAcquire(priority) {
+		return false
+	}
+
+	if l.parent != nil && !l.parent.TryAcquire(priority) {
+		l.abandon(1)
+		return false
+	}
+
+	return true
+}
+
+// tryAcquire takes the fast path from this Limiter, ignoring any parent.
+func (l *Limiter) tryAcquire(priority int) bool {
+	l.mu.Lock()
+
+	if !l.fastPath(1) {
+		l.mu.Unlock()
+		return false
+	}
+
+	l.mu.Unlock()
+
+	if l.observer != nil {
+		l.observe(context.Background(), priority, time.Now(), AcquireFast, false, nil)
+	}
+	return true
+}
+
+// Reservation is a place in a Limiter's queue, made with Reserve.
+type Reservation struct {
+	// limiters are the Limiter, and then each of its parents, which
+	// are reserved from in that order
+	limiters []*Limiter
+	priority int
+	start    time.Time
+
+	// ready is closed once finished is set
+	ready    chan struct{}
+	finished int32
+
+	mu sync.Mutex
+	// level is the index of the Limiter being reserved from, and the
+	// slots from those before it are held
+	level int
+	// waiter is queued at level, if its slot wasn't free
+	waiter   *rendezvouz
+	err      error
+	canceled bool
+}
+
+// Reserve queues for a Lock without blocking. The Reservation's Ready
+// channel is closed once it is acquired or fails, so callers can select
+// on several Limiters, and Cancel the ones they don't use. An acquired
+// Reservation is released with Release, like Acquire. If the Limiter
+// has parents, they are reserved from in order once it is acquired,
+// like AcquireN, so a slot in a parent isn't held while waiting on a
+// child.
+func (l *Limiter) Reserve(priority int) *Reservation {
+	r := &Reservation{
+		priority: priority,
+		start:    time.Now(),
+		ready:    make(chan struct{}),
+	}
+
+	for limiter := l; limiter != nil; limiter = limiter.parent {
+		r.limiters = append(r.limiters, limiter)
+	}
+
+	r.mu.Lock()
+	r.advance()
+	held := r.failed()
+	r.mu.Unlock()
+
+	abandon(held)
+
+	return r
+}
+
+// advance reserves a slot from each Limiter from level on, until one
+// has to be queued for or fails. It must be called with the lock held.
+func (r *Reservation) advance() {
+	for r.level < len(r.limiters) {
+		l := r.limiters[r.level]
+		l.mu.Lock()
+
+		if l.fastPath(1) {
+			l.mu.Unlock()
+
+			l.observe(context.Background(), r.priority, time.Now(), AcquireFast, false, nil)
+			r.level++
+			continue
+		}
+
+		waiter := &rendezvouz{
+			priority:    r.priority,
+			n:           1,
+			enqueued:    time.Now(),
+			errChan:     make(chan error, 1),
+			reservation: r,
+		}
+
+		err := l.enqueue(waiter, "")
+		l.mu.Unlock()
+
+		if err != nil {
+			l.observe(context.Background(), r.priority, r.start, AcquireDropped, false, err)
+
+			r.err = err
+			r.finish()
+			return
+		}
+
+		r.waiter = waiter
+		return
+	}
+
+	r.finish()
+}
+
+// granted moves on to the next Limiter once the waiter has been
+// admitted. It is called by whoever admitted it, after unlocking that
+// Limiter, so Limiters are only ever locked child first.
+func (r *Reservation) granted() {
+	r.mu.Lock()
+
+	if !r.received() {
+		r.mu.Unlock()
+		return
+	}
+
+	r.advance()
+	held := r.failed()
+	r.mu.Unlock()
+
+	abandon(held)
+}
+
+// received takes the waiter's result, if it has one, returning if it
+// did. It must be called with the lock held.
+func (r *Reservation) received() bool {
+	if r.waiter == nil {
+		return false
+	}
+
+	select {
+	case err := <-r.waiter.errChan:
+		r.result(err)
+		return true
+	default:
+		return false
+	}
+}
+
+// result records the waiter's result. It must be called with the lock
+// held.
+func (r *Reservation) result(err error) {
+	l := r.limiters[r.level]
+	l.observe(context.Background(), r.priority, r.start, queuedOutcome(err), true, err)
+
+	r.waiter = nil
+	if err != nil {
+		r.err = err
+	} else {
+		r.level++
+	}
+}
+
+// failed returns the Limiters whose slots are held by a Reservation
+// that failed, which the caller must abandon after unlocking, since
+// that can admit other Reservations. It must be called with the lock
+// held.
+func (r *Reservation) failed() []*Limiter {
+	if r.err == nil {
+		return nil
+	}
+	return r.giveBack()
+}
+
+// giveBack returns the Limiters whose slots are held, and forgets
+// them. It must be called with the lock held.
+func (r *Reservation) giveBack() []*Limiter {
+	held := r.limiters[:r.level]
+	r.level = 0
+	return held
+}
+
+// abandon a slot in each of the Limiters.
+func abandon(limiters []*Limiter) {
+	for _, l := range limiters {
+		l.abandon(1)
+	}
+}
+
+// finish closes ready, if it hasn't been already.
+func (r *Reservation) finish() {
+	if atomic.CompareAndSwapInt32(&r.finished, 0, 1) {
+		close(r.ready)
+	}
+}
+
+// Ready returns a channel that is closed once the Reservation is
+// acquired or fails.
+func (r *Reservation) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// Err waits for the Reservation, and returns nil if it was acquired,
+// or the error if it failed, e.g. Dropped. A Reservation that fails
+// holds none of its slots.
+func (r *Reservation) Err() error {
+	<-r.ready
+
+	r.mu.Lock()
+	r.received()
+	err := r.err
+	held := r.failed()
+	r.mu.Unlock()
+
+	abandon(held)
+
+	return err
+}
+
+// Cancel gives up the Reservation. If it was already acquired its slot
+// is released without updating the limit, so it must not be called
+// after the caller has released it.
+func (r *Reservation) Cancel() {
+	r.mu.Lock()
+
+	if r.canceled {
+		r.mu.Unlock()
+		return
+	}
+	r.canceled = true
+
+	if r.waiter != nil {
+		l := r.limiters[r.level]
+
+		var err error
+		withdrawn := false
+
+		l.mu.Lock()
+
+		select {
+		case err = <-r.waiter.errChan:
+		default:
+			l.withdraw(r.waiter)
+			withdrawn = true
+		}
+
+		l.mu.Unlock()
+
+		if withdrawn {
+			l.observe(context.Background(), r.priority, r.start, AcquireCanceled, true, context.Canceled)
+
+			r.waiter = nil
+			r.err = context.Canceled
+		} else {
+			r.result(err)
+		}
+	}
+
+	// Ignore the slot at every level, as if it was never used
+	held := r.giveBack()
+	r.finish()
+	r.mu.Unlock()
+
+	abandon(held)
+}
@@ -0,0 +1,341 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1})
+
+	if !c.TryAcquire(0) {
+		t.Fatal("Expected to acquire")
+	}
+
+	if c.TryAcquire(0) {
+		t.Error("Expected not to acquire when full")
+	}
+
+	if c.waiters.Len() != 0 {
+		t.Errorf("Got %d queued, expected %d", c.waiters.Len(), 0)
+	}
+
+	c.Release()
+
+	if c.outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", c.outstanding, 0)
+	}
+}
+
+func TestTryAcquireParent(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	if !parent.TryAcquire(0) {
+		t.Fatal("Expected to acquire")
+	}
+
+	if child.TryAcquire(0) {
+		t.Error("Expected not to acquire when the parent is full")
+	}
+
+	if child.outstanding != 0 {
+		t.Errorf("Got %d outstanding in the child, expected %d", child.outstanding, 0)
+	}
+
+	parent.Release()
+
+	if !child.TryAcquire(0) {
+		t.Fatal("Expected to acquire")
+	}
+
+	child.Release()
+
+	if child.outstanding != 0 || parent.outstanding != 0 {
+		t.Errorf("Got %d and %d outstanding, expected none", child.outstanding, parent.outstanding)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1})
+
+	r := c.Reserve(0)
+	select {
+	case <-r.Ready():
+	default:
+		t.Fatal("Expected to be ready")
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	queued := c.Reserve(0)
+	waitForQueued(&c, 1)
+
+	select {
+	case <-queued.Ready():
+		t.Fatal("Expected to wait")
+	default:
+	}
+
+	c.Release()
+
+	select {
+	case <-queued.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting to be ready")
+	}
+
+	if err := queued.Err(); err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.Release()
+}
+
+func TestReserveCancel(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 1})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	r := c.Reserve(0)
+	waitForQueued(&c, 1)
+
+	r.Cancel()
+	r.Cancel()
+
+	if c.waiters.Len() != 0 {
+		t.Errorf("Got %d queued, expected %d", c.waiters.Len(), 0)
+	}
+
+	c.Release()
+
+	// Canceling an acquired reservation releases it
+	r = c.Reserve(0)
+	if err := r.Err(); err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	r.Cancel()
+
+	if c.outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", c.outstanding, 0)
+	}
+}
+
+func TestReserveSelect(t *testing.T) {
+	a := New(Config{Capacity: 10, MaxLimit: 1})
+	b := New(Config{Capacity: 10, MaxLimit: 1})
+
+	a.TryAcquire(0)
+	b.TryAcquire(0)
+
+	ra := a.Reserve(0)
+	rb := b.Reserve(0)
+
+	waitForQueued(&a, 1)
+	waitForQueued(&b, 1)
+
+	b.Release()
+
+	select {
+	case <-ra.Ready():
+		t.Fatal("Expected b to be ready first")
+	case <-rb.Ready():
+		ra.Cancel()
+	}
+
+	if err := rb.Err(); err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	a.Release()
+	b.Release()
+
+	if a.outstanding != 0 || b.outstanding != 0 {
+		t.Errorf("Got %d and %d outstanding, expected none", a.outstanding, b.outstanding)
+	}
+}
+
+func TestReserveDoesNotStartGoroutines(t *testing.T) {
+	c := New(Config{Capacity: 100, MaxLimit: 1})
+	c.TryAcquire(0)
+
+	before := runtime.NumGoroutine()
+
+	rs := make([]*Reservation, 50)
+	for i := range rs {
+		rs[i] = c.Reserve(0)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Got %d goroutines, expected at most %d", after, before)
+	}
+
+	for _, r := range rs {
+		r.Cancel()
+	}
+
+	if s := c.Stats(); s.Queued != 0 || s.Outstanding != 1 {
+		t.Errorf("Got %d queued and %d outstanding, expected %d and %d", s.Queued, s.Outstanding, 0, 1)
+	}
+
+	c.Release()
+}
+
+func TestReserveParent(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	parent.TryAcquire(0)
+
+	// The child's slot is free, but the parent's isn't
+	r := child.Reserve(0)
+
+	select {
+	case <-r.Ready():
+		t.Fatal("Expected to wait on the parent")
+	default:
+	}
+
+	parent.Release()
+
+	select {
+	case <-r.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting to be ready")
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if child.outstanding != 1 || parent.outstanding != 1 {
+		t.Errorf("Got %d and %d outstanding, expected %d and %d", child.outstanding, parent.outstanding, 1, 1)
+	}
+
+	child.Release()
+
+	if child.outstanding != 0 || parent.outstanding != 0 {
+		t.Errorf("Got %d and %d outstanding, expected none", child.outstanding, parent.outstanding)
+	}
+}
+
+func TestReserveParentDropped(t *testing.T) {
+	parent := New(Config{Capacity: 0, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	parent.TryAcquire(0)
+
+	r := child.Reserve(0)
+
+	if err := r.Err(); !errors.Is(err, Dropped) {
+		t.Errorf("Got %v, expected %v", err, Dropped)
+	}
+
+	// The child's slot is given back
+	if child.outstanding != 0 {
+		t.Errorf("Got %d outstanding in the child, expected %d", child.outstanding, 0)
+	}
+
+	r.Cancel()
+
+	if child.outstanding != 0 || parent.outstanding != 1 {
+		t.Errorf("Got %d and %d outstanding, expected %d and %d", child.outstanding, parent.outstanding, 0, 1)
+	}
+
+	parent.Release()
+}
+
+func TestReserveParentAfterChild(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 1, Parent: &parent})
+	sibling := New(Config{Capacity: 10, MaxLimit: 10, Parent: &parent})
+
+	err := sibling.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	// This holds the child's slot while it waits on the parent
+	done := make(chan error)
+	go func() {
+		done <- child.Acquire(context.Background(), 0)
+	}()
+	waitForQueued(&parent, 1)
+
+	// So this waits on the child, without queueing on the parent
+	r := child.Reserve(5)
+	waitForQueued(&child, 1)
+
+	if s := parent.Stats(); s.Queued != 1 {
+		t.Errorf("Got %d queued in the parent, expected %d", s.Queued, 1)
+	}
+
+	sibling.Release()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("Got an error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting to acquire")
+	}
+
+	child.Release()
+
+	select {
+	case <-r.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting to be ready")
+	}
+
+	if err := r.Err(); err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	child.Release()
+
+	if child.outstanding != 0 || parent.outstanding != 0 {
+		t.Errorf("Got %d and %d outstanding, expected none", child.outstanding, parent.outstanding)
+	}
+}
+
+func TestReserveCancelOnParent(t *testing.T) {
+	parent := New(Config{Capacity: 10, MaxLimit: 1})
+	child := New(Config{Capacity: 10, MaxLimit: 1, Parent: &parent})
+
+	child.tryAcquire(0)
+	parent.tryAcquire(0)
+
+	r := child.Reserve(0)
+	waitForQueued(&child, 1)
+
+	// The child's slot is handed on to the parent, which is still held
+	child.abandon(1)
+	waitForQueued(&parent, 1)
+
+	r.Cancel()
+
+	if s := child.Stats(); s.Outstanding != 0 {
+		t.Errorf("Got %d outstanding in the child, expected %d", s.Outstanding, 0)
+	}
+
+	if s := parent.Stats(); s.Outstanding != 1 || s.Queued != 0 {
+		t.Errorf("Got %d outstanding and %d queued in the parent, expected %d and %d", s.Outstanding, s.Queued, 1, 0)
+	}
+
+	if err := r.Err(); err != context.Canceled {
+		t.Errorf("Got %v, expected %v", err, context.Canceled)
+	}
+
+	parent.Release()
+}
@@ -19,9 +19,26 @@ func msToWait(perSec int64) time.Duration {
 }
 
 type Capped struct {
-	mu  sync.Mutex
-	cur int64
-	cap int64
+	mu   sync.Mutex
+	cond *sync.Cond
+	cur  int64
+	cap  int64
+}
+
+// Wait blocks until the process has capacity, modeling an upstream
+// that queues excess requests instead of rejecting them.
+func (s *Capped) Wait() {
+	s.mu.Lock()
+	if s.cond == nil {
+		s.cond = sync.NewCond(&s.mu)
+	}
+
+	for s.cur >= s.cap {
+		s.cond.Wait()
+	}
+
+	s.cur++
+	s.mu.Unlock()
 }
 
 func (s *Capped) Lock() error {
@@ -40,6 +57,9 @@ func (s *Capped) Lock() error {
 func (s *Capped) Unlock() {
 	s.mu.Lock()
 	s.cur--
+	if s.cond != nil {
+		s.cond.Signal()
+	}
 	s.mu.Unlock()
 }
 
@@ -106,7 +126,7 @@ func TestConcurrentSimulation(t *testing.T) {
 			// Wait for the inner loop to finish
 			inner.Wait()
 
-			t.Logf("limit=%d, success=%f", limiter.limit, (float64(success) / iterations))
+			t.Logf("limit=%d, success=%f", limiter.Stats().Limit, (float64(success) / iterations))
 
 		}()
 	}
@@ -183,7 +203,7 @@ func TestBackoffConcurrentSimulation(t *testing.T) {
 			// Wait for the inner loop to finish
 			inner.Wait()
 
-			t.Logf("priority=%d, limit=%d, success=%f", priority, limiter.limit, (float64(success) / (iterations)))
+			t.Logf("priority=%d, limit=%d, success=%f", priority, limiter.Stats().Limit, (float64(success) / (iterations)))
 
 		}()
 	}
@@ -191,3 +211,84 @@ func TestBackoffConcurrentSimulation(t *testing.T) {
 	wg.Wait()
 
 }
+
+// Simulate a process with 1000 reqs/second against a process that
+// can process 10 concurrent at 100 reqs/second, and queues anything
+// over that. Nothing signals a backoff, so the latency based
+// algorithms should converge on a limit near 10 from the added
+// latency alone.
+func TestLatencySimulation(t *testing.T) {
+	if !(*sim) {
+		t.Log("Skipping sim since -sim not passed")
+		t.Skip()
+	}
+
+	const (
+		perSecond   = 1000
+		testSeconds = 2
+		iterations  = perSecond * testSeconds
+	)
+
+	algorithms := []struct {
+		Name      string
+		Algorithm func() LimitAlgorithm
+	}{
+		{"Vegas", func() LimitAlgorithm { return NewVegas() }},
+		{"Gradient2", func() LimitAlgorithm { return NewGradient2() }},
+	}
+
+	for _, a := range algorithms {
+		a := a
+		t.Run(a.Name, func(t *testing.T) {
+			c := Capped{cap: 10}
+			wg := sync.WaitGroup{}
+
+			limiter := New(Config{
+				Capacity:  100,
+				MaxLimit:  100,
+				Algorithm: a.Algorithm,
+			})
+
+			success := int64(0)
+
+			// Average the limit over the second half, once it has warmed up
+			sampled, samples := 0, 0
+
+			for i := 0; i < iterations; i++ {
+				time.Sleep(msToWait(perSecond))
+
+				if i >= iterations/2 && i%10 == 0 {
+					sampled += limiter.Stats().Limit
+					samples++
+				}
+
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					err := limiter.Acquire(context.Background(), 0)
+					if err != nil {
+						return
+					}
+					defer limiter.Release()
+
+					c.Wait()
+					defer c.Unlock()
+
+					time.Sleep(msToWait(100))
+					atomic.AddInt64(&success, 1)
+				}()
+			}
+
+			wg.Wait()
+
+			limit := float64(sampled) / float64(samples)
+			t.Logf("limit=%d, average=%f, success=%f", limiter.Stats().Limit, limit, (float64(success) / iterations))
+
+			if limit > 2*float64(c.cap) {
+				t.Errorf("Got an average limit of %f, expected it to converge near %d", limit, c.cap)
+			}
+		})
+	}
+}
@@ -3,23 +3,24 @@ package congestion
 import "fmt"
 
 const (
-	slowStart = stage(iota)
-	waiting
-	increasing
-	recovering
+	SlowStart = Stage(iota + 1)
+	Waiting
+	Increasing
+	Recovering
 )
 
-type stage int
+// Stage is the state of the default AIMD algorithm
+type Stage int
 
-func (p stage) String() string {
+func (p Stage) String() string {
 	switch p {
-	case slowStart:
+	case SlowStart:
 		return "slowStart"
-	case waiting:
+	case Waiting:
 		return "waiting"
-	case increasing:
+	case Increasing:
 		return "increasing"
-	case recovering:
+	case Recovering:
 		return "recovering"
 	}
 	return fmt.Sprintf("stage(%d)", p)
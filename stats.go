@@ -0,0 +1,72 @@
+package congestion
+
+import "time"
+
+// counters are cumulative counts of Limiter events
+type counters struct {
+	acquisitions  uint64
+	fastPath      uint64
+	drops         uint64
+	cancellations uint64
+	backoffs      uint64
+}
+
+// Stats is a snapshot of a Limiter's state.
+type Stats struct {
+	// Limit is the current concurrency limit
+	Limit int
+	// Outstanding is the number of acquired tokens that haven't been released
+	Outstanding int
+	// Queued is the number of waiters in the queue
+	Queued int
+	// PausedUntil is when the Limiter resumes admitting after BackoffUntil, or zero if it isn't paused
+	PausedUntil time.Time
+
+	// Stage and AcksLeft are the state of the default AIMD algorithm,
+	// and are zero for other algorithms.
+	Stage    Stage
+	AcksLeft int
+
+	// Acquisitions is the number of tokens acquired
+	Acquisitions uint64
+	// FastPath is the number of tokens acquired without waiting in the queue
+	FastPath uint64
+	// Drops is the number of waiters that were never admitted, because
+	// they were rejected or evicted from a full queue, shed by CoDel or
+	// SetCapacity, or failed with ErrDeadline
+	Drops uint64
+	// Cancellations is the number of waiters whose context ended while queued
+	Cancellations uint64
+	// Backoffs is the number of backoff signals
+	Backoffs uint64
+}
+
+// Stats returns a consistent snapshot of the Limiter's state.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+
+	s := Stats{
+		Limit:         l.limit,
+		Outstanding:   l.outstanding,
+		Queued:        l.waiters.Len(),
+		PausedUntil:   l.pausedUntil,
+		Acquisitions:  l.counters.acquisitions,
+		FastPath:      l.counters.fastPath,
+		Drops:         l.counters.drops,
+		Cancellations: l.counters.cancellations,
+		Backoffs:      l.counters.backoffs,
+	}
+
+	if !l.paused() {
+		s.PausedUntil = time.Time{}
+	}
+
+	if a, ok := l.algorithm.(*aimd); ok {
+		s.Stage = a.stage
+		s.AcksLeft = a.acksLeft
+	}
+
+	l.mu.Unlock()
+
+	return s
+}
@@ -0,0 +1,78 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	c := New(Config{Capacity: 1, MaxLimit: 10})
+	ctx := context.Background()
+
+	err := c.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	// Queue a waiter, then cancel it
+	canceled, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(canceled, 0)
+	}()
+
+	waitForQueued(&c, 1)
+
+	// The queue is full, so this is dropped
+	err = c.Acquire(ctx, 0)
+	if !errors.Is(err, Dropped) {
+		t.Errorf("Got %v, expected %v", err, Dropped)
+	}
+
+	cancel()
+	<-done
+
+	c.Backoff()
+
+	expected := Stats{
+		Limit:         1,
+		Outstanding:   1,
+		Queued:        0,
+		Stage:         Recovering,
+		AcksLeft:      1,
+		Acquisitions:  1,
+		FastPath:      1,
+		Drops:         1,
+		Cancellations: 1,
+		Backoffs:      1,
+	}
+
+	actual := c.Stats()
+	if actual != expected {
+		t.Errorf("Got %+v, expected %+v", actual, expected)
+	}
+
+	c.Release()
+}
+
+func TestStatsOtherAlgorithm(t *testing.T) {
+	c := New(Config{
+		Capacity:  1,
+		MaxLimit:  10,
+		Algorithm: func() LimitAlgorithm { return NewVegas() },
+	})
+
+	s := c.Stats()
+	if s.Stage != 0 || s.AcksLeft != 0 {
+		t.Errorf("Got stage=%s acksLeft=%d, expected zero values", s.Stage, s.AcksLeft)
+	}
+}
+
+func BenchmarkStats(b *testing.B) {
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+
+	for i := 0; i < b.N; i++ {
+		c.Stats()
+	}
+}
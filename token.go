@@ -0,0 +1,121 @@
+package congestion
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrReleased is the error returned when a Token is released more than once
+var ErrReleased = errors.New("token already released")
+
+// Token is a slot acquired from a Limiter. It must be released exactly
+// once, by reporting the outcome of the work it guarded.
+type Token struct {
+	limiter  *Limiter
+	start    time.Time
+	released bool
+}
+
+// AcquireToken acquires a Token with FIFO ordering, respecting the
+// context. Returns an error if it fails to acquire.
+func (l *Limiter) AcquireToken(ctx context.Context, priority int) (*Token, error) {
+	err := l.Acquire(ctx, priority)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		limiter: l,
+		start:   time.Now(),
+	}, nil
+}
+
+// release the token, letting the limiter's algorithm update the limit,
+// and parent update the limit of any parents.
+func (t *Token) release(update, parent func(l *Limiter, s Sample)) error {
+	l := t.limiter
+
+	l.mu.Lock()
+
+	// A token can only be released once, and never when something else
+	// has already released its slot
+	if t.released || l.outstanding <= 0 {
+		l.mu.Unlock()
+		return ErrReleased
+	}
+
+	// Keep the anonymous release timings balanced, but use our own,
+	// which is exact.
+	l.rtt(1)
+
+	rtt := time.Since(t.start)
+
+	s := l.sample()
+	s.RTT = rtt
+
+	if update != nil {
+		update(l, s)
+	}
+	l.served(rtt)
+
+	t.released = true
+	l.release(1)
+
+	l.unlock()
+
+	if l.parent != nil {
+		l.parent.releaseWith(rtt, parent)
+	}
+
+	return nil
+}
+
+func success(l *Limiter, s Sample) {
+	l.setLimit(l.algorithm.Success(s))
+}
+
+func backoff(l *Limiter, s Sample) {
+	l.backoff(s)
+}
+
+// Success releases the token, signaling that the upstream handled the
+// request. Any parents are updated too.
+func (t *Token) Success() error {
+	return t.release(success, success)
+}
+
+// Backoff releases the token, signaling that the upstream is
+// overloaded. Only the token's own Limiter backs off, not its parents.
+func (t *Token) Backoff() error {
+	return t.release(backoff, nil)
+}
+
+// BackoffParent releases the token, signaling that the overload is in
+// the parent's scope, e.g. an account wide limit was hit rather than
+// an endpoint's. The parent backs off, and the token's own Limiter is
+// left unchanged. It is the same as Backoff without a parent.
+func (t *Token) BackoffParent() error {
+	if t.limiter.parent == nil {
+		return t.Backoff()
+	}
+
+	return t.release(nil, func(l *Limiter, s Sample) {
+		if l == t.limiter.parent {
+			l.backoff(s)
+		}
+	})
+}
+
+// Dropped releases the token, signaling that the request was lost,
+// e.g. it timed out. This is treated as a sign of overload, at the
+// token's own Limiter.
+func (t *Token) Dropped() error {
+	return t.release(backoff, nil)
+}
+
+// Ignore releases the token without updating the limit, e.g. when the
+// request failed for reasons unrelated to the upstream's load.
+func (t *Token) Ignore() error {
+	return t.release(nil, nil)
+}
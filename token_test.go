@@ -0,0 +1,92 @@
+package congestion
+
+import (
+	"context"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Release  func(t *Token) error
+		Expected int
+	}{
+		{"Success", (*Token).Success, 2},
+		{"Backoff", (*Token).Backoff, 1},
+		{"Dropped", (*Token).Dropped, 1},
+		{"Ignore", (*Token).Ignore, 1},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			c := New(Config{Capacity: 10, MaxLimit: 10})
+
+			token, err := c.AcquireToken(context.Background(), 0)
+			if err != nil {
+				t.Fatal("Got an error:", err)
+			}
+
+			err = tc.Release(token)
+			if err != nil {
+				t.Error("Got an error:", err)
+			}
+
+			if c.limit != tc.Expected {
+				t.Errorf("Got limit %d, expected %d", c.limit, tc.Expected)
+			}
+
+			if c.outstanding != 0 {
+				t.Errorf("Got %d outstanding, expected 0", c.outstanding)
+			}
+
+			err = tc.Release(token)
+			if err != ErrReleased {
+				t.Errorf("Got %v releasing twice, expected %v", err, ErrReleased)
+			}
+		})
+	}
+}
+
+func TestTokenHandsOffToWaiter(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+
+	token, err := c.AcquireToken(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.Acquire(context.Background(), 0)
+	}()
+
+	// Backoff keeps the limit at 1, so the waiter only gets in once the token is released
+	err = token.Backoff()
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Error("Got an error:", err)
+	}
+
+	c.Release()
+}
+
+func TestTokenReleasedElsewhere(t *testing.T) {
+	c := New(Config{Capacity: 10, MaxLimit: 10})
+
+	token, err := c.AcquireToken(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	c.Release()
+
+	err = token.Success()
+	if err != ErrReleased {
+		t.Errorf("Got %v, expected %v", err, ErrReleased)
+	}
+}
@@ -0,0 +1,206 @@
+package congestion
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRetries is the most times a Transport retries a request,
+// unless MaxRetries is set
+const DefaultMaxRetries = 3
+
+type priorityKey struct{}
+
+// WithPriority returns a context carrying the priority for requests made with it
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set by WithPriority, if any
+func PriorityFromContext(ctx context.Context) (int, bool) {
+	priority, ok := ctx.Value(priorityKey{}).(int)
+	return priority, ok
+}
+
+// Transport is an http.RoundTripper that limits requests with a
+// Limiter. Responses with a 429 or 503 status signal a backoff, and the
+// request is retried with a Backoff, honoring any Retry-After header.
+type Transport struct {
+	// Base makes the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// Limiter limits the requests
+	Limiter *Limiter
+	// Step is the initial delay between retries
+	Step time.Duration
+	// MaxRetries is the most times a request is retried. Zero uses
+	// DefaultMaxRetries. A negative value retries until the request's
+	// context ends, but only if it has a deadline.
+	MaxRetries int
+	// PriorityHeader names a request header holding the priority, used
+	// when the request's context has none from WithPriority.
+	PriorityHeader string
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// retries returns the most times a request with the context is
+// retried, or -1 to retry until it ends.
+func (t *Transport) retries(ctx context.Context) int {
+	if t.MaxRetries > 0 {
+		return t.MaxRetries
+	}
+
+	if _, ok := ctx.Deadline(); ok && t.MaxRetries < 0 {
+		return -1
+	}
+	return DefaultMaxRetries
+}
+
+// priority of the request, from its context, then its header, defaulting to 0.
+func (t *Transport) priority(req *http.Request) int {
+	if priority, ok := PriorityFromContext(req.Context()); ok {
+		return priority
+	}
+
+	if priority, ok := headerPriority(req.Header, t.PriorityHeader); ok {
+		return priority
+	}
+
+	return 0
+}
+
+// headerPriority parses the priority from the named header, if it is set
+func headerPriority(header http.Header, name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+
+	priority, err := strconv.Atoi(header.Get(name))
+	if err != nil {
+		return 0, false
+	}
+
+	return priority, true
+}
+
+// shouldBackoff returns if the response signals the upstream is overloaded
+func shouldBackoff(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// retryAfter parses the response's Retry-After header, which is either
+// a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	b := Backoff{
+		Step:     t.Step,
+		Limiter:  t.Limiter,
+		Priority: t.priority(req),
+	}
+
+	// Only requests whose body can be replayed can be retried
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	retries := t.retries(ctx)
+
+	// last is the latest overloaded response, kept in case it can't be
+	// retried after all
+	var last *http.Response
+
+	for attempt := 0; b.Try(ctx); attempt++ {
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				b.Close()
+				return nil, err
+			}
+
+			r = req.Clone(ctx)
+			r.Body = body
+		}
+
+		resp, err := t.base().RoundTrip(r)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+
+		if !shouldBackoff(resp) || !canRetry || (retries >= 0 && attempt >= retries) {
+			// We won't retry, but still need to signal the overload
+			if shouldBackoff(resp) {
+				if delay, ok := retryAfter(resp); ok {
+					t.Limiter.BackoffUntil(time.Now().Add(delay))
+				} else {
+					t.Limiter.Backoff()
+				}
+			}
+
+			// Hold the limiter until the body has been read
+			resp.Body = &releaseBody{ReadCloser: resp.Body, backoff: &b}
+			return resp, nil
+		}
+
+		if delay, ok := retryAfter(resp); ok {
+			b.RetryAfter(delay)
+		}
+
+		// Read the body so the connection can be reused while we wait
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		last = resp
+	}
+
+	b.Close()
+
+	// The upstream's answer is more useful than why we couldn't retry,
+	// e.g. a Retry-After past the deadline, unless the request is over
+	if last != nil && ctx.Err() == nil {
+		return last, nil
+	}
+	return nil, b.Error
+}
+
+// releaseBody closes the Backoff when the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	backoff *Backoff
+	once    sync.Once
+}
+
+func (r *releaseBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.backoff.Close)
+	return err
+}
@@ -0,0 +1,282 @@
+package congestion
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// overloaded returns the status for the first n requests, then 200
+func overloaded(status int, n int64) (http.Handler, *int64) {
+	var requests int64
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if atomic.AddInt64(&requests, 1) <= n {
+			w.WriteHeader(status)
+			return
+		}
+
+		w.Write(body)
+	}), &requests
+}
+
+func TestTransport(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		handler, requests := overloaded(status, 2)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		l := New(Config{Capacity: 10, MaxLimit: 10})
+		client := http.Client{
+			Transport: &Transport{
+				Limiter: &l,
+				Step:    time.Millisecond,
+			},
+		}
+
+		resp, err := client.Post(server.URL, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal("Got an error:", err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Errorf("Got %d %q, expected %d %q", resp.StatusCode, body, http.StatusOK, "hello")
+		}
+
+		if *requests != 3 {
+			t.Errorf("Got %d requests, expected %d", *requests, 3)
+		}
+
+		s := l.Stats()
+		if s.Backoffs != 2 || s.Outstanding != 0 {
+			t.Errorf("Got %d backoffs and %d outstanding, expected %d and %d", s.Backoffs, s.Outstanding, 2, 0)
+		}
+	}
+}
+
+func TestTransportMaxRetries(t *testing.T) {
+	handler, requests := overloaded(http.StatusTooManyRequests, 10)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	l := New(Config{Capacity: 10, MaxLimit: 10})
+	client := http.Client{
+		Transport: &Transport{
+			Limiter:    &l,
+			Step:       time.Millisecond,
+			MaxRetries: 2,
+		},
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Got %d, expected %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+
+	if *requests != 3 {
+		t.Errorf("Got %d requests, expected %d", *requests, 3)
+	}
+
+	s := l.Stats()
+	if s.Backoffs != 3 || s.Outstanding != 0 {
+		t.Errorf("Got %d backoffs and %d outstanding, expected %d and %d", s.Backoffs, s.Outstanding, 3, 0)
+	}
+}
+
+func TestTransportDefaultRetries(t *testing.T) {
+	handler, requests := overloaded(http.StatusServiceUnavailable, 100)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	l := New(Config{Capacity: 10, MaxLimit: 10})
+	client := http.Client{
+		Transport: &Transport{
+			Limiter:    &l,
+			Step:       time.Millisecond,
+			MaxRetries: -1,
+		},
+	}
+
+	// Without a deadline, this would otherwise retry forever
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	resp.Body.Close()
+
+	if *requests != DefaultMaxRetries+1 {
+		t.Errorf("Got %d requests, expected %d", *requests, DefaultMaxRetries+1)
+	}
+
+	if s := l.Stats(); s.Outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 0)
+	}
+}
+
+func TestTransportRetryAfterDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("try later"))
+	}))
+	defer server.Close()
+
+	l := New(Config{Capacity: 10, MaxLimit: 10})
+	client := http.Client{
+		Transport: &Transport{
+			Limiter: &l,
+			Step:    time.Millisecond,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+	// It can't wait a minute, so the caller gets the upstream's answer
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != "try later" {
+		t.Errorf("Got %d %q, expected %d %q", resp.StatusCode, body, http.StatusServiceUnavailable, "try later")
+	}
+
+	if s := l.Stats(); s.Outstanding != 0 {
+		t.Errorf("Got %d outstanding, expected %d", s.Outstanding, 0)
+	}
+}
+
+func TestTransportHoldsLimiterUntilBodyClosed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	l := New(Config{Capacity: 10, MaxLimit: 10})
+	client := http.Client{
+		Transport: &Transport{Limiter: &l},
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	if n := l.Stats().Outstanding; n != 1 {
+		t.Errorf("Got %d outstanding before close, expected %d", n, 1)
+	}
+
+	resp.Body.Close()
+	resp.Body.Close()
+
+	if n := l.Stats().Outstanding; n != 0 {
+		t.Errorf("Got %d outstanding after close, expected %d", n, 0)
+	}
+}
+
+func TestTransportPriority(t *testing.T) {
+	transport := Transport{PriorityHeader: "X-Priority"}
+
+	cases := []struct {
+		Context  context.Context
+		Header   string
+		Expected int
+	}{
+		{context.Background(), "", 0},
+		{context.Background(), "7", 7},
+		{context.Background(), "junk", 0},
+		{WithPriority(context.Background(), 3), "7", 3},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tc.Context)
+		if tc.Header != "" {
+			req.Header.Set("X-Priority", tc.Header)
+		}
+
+		actual := transport.priority(req)
+		if actual != tc.Expected {
+			t.Errorf("Got priority %d for header %q, expected %d", actual, tc.Header, tc.Expected)
+		}
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	l := New(Config{Capacity: 10, MaxLimit: 10, InitialLimit: 4})
+	client := http.Client{
+		Transport: &Transport{
+			Limiter: &l,
+			Step:    time.Millisecond,
+		},
+	}
+
+	start := time.Now()
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+	resp.Body.Close()
+
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("Waited %s, expected to honor Retry-After", waited)
+	}
+
+	if l.Stats().Limit != 4 {
+		t.Errorf("Got limit %d, expected it unchanged at %d", l.Stats().Limit, 4)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := []struct {
+		Header   string
+		Expected time.Duration
+		Ok       bool
+	}{
+		{"", 0, false},
+		{"30", 30 * time.Second, true},
+		{"-1", 0, false},
+		{"junk", 0, false},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), time.Minute, true},
+	}
+
+	for _, tc := range cases {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", tc.Header)
+
+		actual, ok := retryAfter(resp)
+
+		// HTTP dates only have second precision
+		if ok != tc.Ok || actual > tc.Expected || actual < tc.Expected-time.Second {
+			t.Errorf("Retry-After %q is %s %t, expected %s %t", tc.Header, actual, ok, tc.Expected, tc.Ok)
+		}
+	}
+}
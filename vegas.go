@@ -0,0 +1,88 @@
+package congestion
+
+import (
+	"math"
+	"time"
+)
+
+// Vegas is a latency based LimitAlgorithm, modeled after TCP Vegas. It
+// tracks the minimum RTT seen as the upstream's unloaded latency, and
+// uses it to estimate how many requests are queued upstream:
+//
+//	queue = limit * (1 - minRTT / rtt)
+//
+// The limit grows quickly while the estimated queue is small, and
+// shrinks once it grows past a threshold, so it adapts to upstreams
+// that slow down instead of signaling with Backoff.
+type Vegas struct {
+	// Probe resets the minimum RTT after Probe * limit samples, so the
+	// algorithm can notice when the upstream's unloaded latency rises.
+	// Zero disables probing.
+	Probe int
+
+	minRTT  time.Duration
+	samples int
+}
+
+// NewVegas returns a Vegas algorithm with default settings.
+func NewVegas() *Vegas {
+	return &Vegas{
+		Probe: 30,
+	}
+}
+
+// log10 returns the integer log of the limit, with a minimum of 1.
+func log10(limit int) int {
+	l := int(math.Log10(float64(limit)))
+	if l < 1 {
+		return 1
+	}
+	return l
+}
+
+func (v *Vegas) Success(s Sample) int {
+	limit := s.Limit
+
+	// Without timing we can't say anything about the upstream
+	if s.RTT <= 0 {
+		return limit
+	}
+
+	v.samples++
+	if v.Probe > 0 && v.samples > v.Probe*limit {
+		v.samples = 0
+		v.minRTT = 0
+	}
+
+	if v.minRTT == 0 || s.RTT < v.minRTT {
+		v.minRTT = s.RTT
+		return limit
+	}
+
+	queue := int(math.Ceil(float64(limit) * (1 - float64(v.minRTT)/float64(s.RTT))))
+
+	var (
+		threshold = log10(limit)
+		alpha     = 3 * threshold
+		beta      = 6 * threshold
+	)
+
+	switch {
+
+	// Only grow the limit if we're using it, otherwise it is meaningless
+	case queue <= threshold && s.Inflight*2 >= limit:
+		return limit + beta
+
+	case queue < alpha && s.Inflight*2 >= limit:
+		return limit + threshold
+
+	case queue > beta:
+		return limit - threshold
+	}
+
+	return limit
+}
+
+func (v *Vegas) Backoff(s Sample) int {
+	return s.Limit - log10(s.Limit)
+}
@@ -0,0 +1,95 @@
+package congestion
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVegas(t *testing.T) {
+	const minRTT = 10 * time.Millisecond
+
+	cases := []struct {
+		Limit    int
+		Inflight int
+		RTT      time.Duration
+		Expected int
+	}{
+		// No queueing, grow quickly
+		{10, 10, minRTT, 16},
+		// A small queue, grow slowly
+		{10, 10, minRTT * 5 / 4, 11},
+		// Not using the limit, so don't grow
+		{10, 2, minRTT, 10},
+		// Between alpha and beta, hold steady
+		{10, 10, minRTT * 2, 10},
+		// Heavy queueing, shrink
+		{10, 10, minRTT * 4, 9},
+		// Unknown rtt is ignored
+		{10, 10, 0, 10},
+	}
+
+	for _, tc := range cases {
+		v := NewVegas()
+		v.minRTT = minRTT
+
+		actual := v.Success(Sample{Limit: tc.Limit, MaxLimit: 1000, Inflight: tc.Inflight, RTT: tc.RTT})
+
+		if actual != tc.Expected {
+			t.Errorf("Success limit=%d inflight=%d rtt=%s is %d, expected %d", tc.Limit, tc.Inflight, tc.RTT, actual, tc.Expected)
+		}
+	}
+}
+
+func TestVegasProbeResetsMinRTT(t *testing.T) {
+	v := &Vegas{Probe: 1}
+
+	v.Success(Sample{Limit: 2, Inflight: 2, RTT: time.Millisecond})
+	v.Success(Sample{Limit: 2, Inflight: 2, RTT: 2 * time.Millisecond})
+	v.Success(Sample{Limit: 2, Inflight: 2, RTT: 3 * time.Millisecond})
+
+	if v.minRTT != 3*time.Millisecond {
+		t.Errorf("Got minRTT %s, expected it to reset to %s", v.minRTT, 3*time.Millisecond)
+	}
+}
+
+// recordingAlgorithm keeps the samples it was given
+type recordingAlgorithm struct {
+	samples []Sample
+}
+
+func (r *recordingAlgorithm) Success(s Sample) int {
+	r.samples = append(r.samples, s)
+	return s.Limit
+}
+
+func (r *recordingAlgorithm) Backoff(s Sample) int {
+	return s.Limit
+}
+
+func TestLimiterTimesTokens(t *testing.T) {
+	const hold = 5 * time.Millisecond
+
+	r := &recordingAlgorithm{}
+	c := New(Config{
+		Capacity:  10,
+		MaxLimit:  10,
+		Algorithm: func() LimitAlgorithm { return r },
+	})
+
+	err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal("Got an error:", err)
+	}
+
+	time.Sleep(hold)
+	c.Release()
+
+	if len(r.samples) != 1 {
+		t.Fatalf("Got %d samples, expected 1", len(r.samples))
+	}
+
+	if r.samples[0].RTT < hold {
+		t.Errorf("Got rtt %s, expected at least %s", r.samples[0].RTT, hold)
+	}
+}